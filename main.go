@@ -2,21 +2,195 @@ package main
 
 import (
         "bytes"
+        "encoding/json"
         "flag"
         "fmt"
         "go/ast"
         "go/parser"
         "go/token"
+        "io"
         "log"
         "os"
+        "reflect"
+        "sort"
         "strconv"
         "strings"
 )
 
 var (
-        printAST = flag.Bool("ast", false, "print ast")
+        printAST     = flag.Bool("ast", false, "print ast")
+        assertMode   = flag.Bool("assert", false, "emit assert() guards for pointer dereferences and array indexes")
+        standalone   = flag.Bool("standalone", false, "emit func main of package main as the C entry point")
+        maxLine      = flag.Int("max-line", 0, "wrap emitted lines longer than this many columns (0 disables)")
+        inlineSmall  = flag.Bool("inline-small", false, "emit small functions as static inline")
+        report       = flag.String("report", "", "write a JSON list of unsupported constructs to this file")
+        partial      = flag.Bool("partial", false, "emit aborting stubs for functions that cannot be translated")
+        intSize      = flag.Int("int-size", 0, "width in bits of Go int and uint, 32 or 64 (0 keeps C int)")
+        staticConst  = flag.Bool("static-const", false, "emit untyped constants as static const rather than #define")
+        defaultType  = flag.String("default-type", "int", "Go type assumed for := variables whose type cannot be inferred")
+        features     = flag.Bool("features", false, "report the Go features used by the input instead of translating it")
+        errorOut     = flag.Bool("error-out", false, "return T from (T, error) functions and pass the error through a trailing error* err")
+        enumStrings  = flag.Bool("enum-strings", false, "emit a name table and a T_String function for each enum type T")
+        exhaustive   = flag.Bool("exhaustive", false, "warn about switches on an enum type without a default that miss some of its constants")
+        computedGoto = flag.Bool("computed-goto", false, "emit dense integer switches as jump tables of label addresses under GNU C")
 )
 
+var (
+        // fset positions the nodes of the file being translated.
+        fset *token.FileSet
+        // pkgName is the package clause of the file being translated.
+        pkgName string
+        // curFunc is the function whose body is being translated.
+        curFunc *ast.FuncDecl
+        // blockLabels holds the labels of plain blocks in curFunc, which
+        // break leaves through a goto.
+        blockLabels = make(map[string]bool)
+        // breaks holds the statements an unlabeled break would leave,
+        // innermost last.
+        breaks []*breakTarget
+        // comments holds the comments of the file, and asmDirectives
+        // the //goc:asm directives of curFunc still to be emitted.
+        comments      []*ast.CommentGroup
+        asmDirectives []*ast.Comment
+        // labelCount numbers the labels generated in curFunc, and
+        // spreadCount the calls whose results it spreads into arguments.
+        labelCount  int
+        spreadCount int
+        // defers holds the calls deferred so far in curFunc, which runs
+        // them at the end of its body, where its returns jump. Only the
+        // statement topStmt of its body can defer. cleanups holds the
+        // numbers of deferred calls of the labels jumped to.
+        defers   []*ast.CallExpr
+        topStmt  ast.Stmt
+        cleanups = make(map[int]bool)
+        // iotaValue is the value of iota in the const spec being
+        // translated, or -1 outside of one.
+        iotaValue = -1
+        // consts holds the values of the integer constants folded so far.
+        consts = make(map[string]int64)
+        // rangeDepth counts the range loops being emitted, to name the
+        // index of those without one.
+        rangeDepth int
+        // wrapped holds the parameters and locals of curFunc that are
+        // arrays wrapped in a struct.
+        wrapped = make(map[string]bool)
+        // wrappers holds the array wrapper structs and other types
+        // declared on demand so far, and pendingDecls the declarations
+        // still to be written out before the current function.
+        wrappers     = make(map[string]bool)
+        pendingDecls []string
+        // vars maps the parameters and locals of curFunc in scope to
+        // their types, and scope holds those declared in the current
+        // block.
+        vars  = make(map[string]ast.Expr)
+        scope = make(map[string]bool)
+        // globals maps the variables of the file to their types.
+        globals = make(map[string]ast.Expr)
+        // dropped holds the variables whose declaration could not be
+        // translated.
+        dropped = make(map[string]bool)
+        // types holds the type declarations of the file by name.
+        types = make(map[string]*ast.TypeSpec)
+        // funcs holds the functions of the file by name, methods excluded.
+        funcs = make(map[string]*ast.FuncDecl)
+        // methods holds the methods of the file by Type.Method name.
+        methods = make(map[string]*ast.FuncDecl)
+        // inits holds the init functions of the file in source order.
+        inits []*ast.FuncDecl
+        // enums holds the const blocks enumerating the values of the int
+        // types of the file by type name, emitted with their typedef.
+        enums = make(map[string]*ast.GenDecl)
+        // enumConsts holds the constants of each enum type folded so far.
+        enumConsts = make(map[string][]string)
+)
+
+// Unsupported describes a node that could not be translated.
+type Unsupported struct {
+        Type    string `json:"type"`
+        File    string `json:"file"`
+        Line    int    `json:"line"`
+        Col     int    `json:"col"`
+        Snippet string `json:"snippet"`
+}
+
+var (
+        // source is the text of the file being translated.
+        source []byte
+        // unsupported lists every node that could not be translated.
+        unsupported []Unsupported
+)
+
+// unsupport records n as untranslatable and returns the placeholder
+// comment emitted in its stead.
+func unsupport(n ast.Node) string {
+        pos := fset.Position(n.Pos())
+        snippet := ""
+        if end := fset.Position(n.End()); pos.IsValid() && end.Offset <= len(source) {
+                snippet = string(source[pos.Offset:end.Offset])
+                if i := strings.IndexByte(snippet, '\n'); i >= 0 {
+                        snippet = snippet[:i] + " ..."
+                }
+        }
+        typ := fmt.Sprintf("%T", n)
+        unsupported = append(unsupported, Unsupported{
+                Type:    typ,
+                File:    pos.Filename,
+                Line:    pos.Line,
+                Col:     pos.Column,
+                Snippet: snippet,
+        })
+        return fmt.Sprintf("/* unsupported: %s */", typ)
+}
+
+// translateError is a fatal error at a position of the file being
+// translated.
+type translateError struct {
+        pos token.Pos
+        msg string
+}
+
+func (e *translateError) Error() string {
+        return fmt.Sprintf("%s: %s", fset.Position(e.pos), e.msg)
+}
+
+// fail aborts the translation with an error at pos.
+func fail(pos token.Pos, format string, args ...interface{}) {
+        panic(&translateError{pos, fmt.Sprintf(format, args...)})
+}
+
+// Values of __STDC_VERSION__ for the C standards the output may need.
+const (
+        c99 = 199901
+        c11 = 201112
+)
+
+var stdNames = map[int]string{c99: "C99", c11: "C11"}
+
+// cstd is the oldest C standard the emitted code compiles under.
+var cstd int
+
+func requireStd(v int) {
+        if v > cstd {
+                cstd = v
+        }
+}
+
+// includes collects the C headers required by the emitted code.
+var includes = make(map[string]bool)
+
+// stdHeaders maps headers to the C standard that introduced them.
+var stdHeaders = map[string]int{
+        "complex.h":  c99,
+        "inttypes.h": c99,
+        "stdbool.h":  c99,
+        "stdint.h":   c99,
+}
+
+func include(h string) {
+        includes[h] = true
+        requireStd(stdHeaders[h])
+}
+
 type Printer struct {
         bytes.Buffer
         indent int
@@ -52,215 +226,3782 @@ func (p *Printer) Unindent() {
         }
 }
 
+// Wrap soft-wraps every line of the buffer longer than width columns
+// after an argument separator or a binary operator. Continuation lines
+// are indented two levels deeper than the line they continue.
+func (p *Printer) Wrap(width int) {
+        lines := strings.SplitAfter(p.String(), "\n")
+        p.Reset()
+        for _, line := range lines {
+                for _, l := range wrapLine(line, width) {
+                        p.WriteString(l)
+                }
+        }
+}
+
+func wrapLine(line string, width int) []string {
+        body := strings.TrimSuffix(line, "\n")
+        nl := line[len(body):]
+        if len(body) <= width || strings.HasPrefix(strings.TrimLeft(body, " "), "#") {
+                return []string{line}
+        }
+        lead := body[:len(body)-len(strings.TrimLeft(body, " "))]
+        cont := lead + "        "
+
+        out := make([]string, 0)
+        for len(body) > width {
+                brk := wrapPoint(body, len(lead), width)
+                if brk < 0 {
+                        break
+                }
+                out = append(out, strings.TrimRight(body[:brk], " ")+"\n")
+                body = cont + body[brk:]
+                lead = cont
+        }
+        return append(out, body+nl)
+}
+
+// opChars holds the characters of C operators, and binaryOps those of
+// them a line may be broken after.
+const opChars = "+-*/%<>=!&|^~"
+
+var binaryOps = map[string]bool{
+        "+": true, "-": true, "*": true, "/": true, "%": true,
+        "<<": true, ">>": true, "<": true, "<=": true, ">": true, ">=": true,
+        "==": true, "!=": true, "&": true, "^": true, "|": true,
+}
+
+// isOperandEnd reports whether c may end the left operand of a binary
+// operator.
+func isOperandEnd(c byte) bool {
+        return c == ')' || c == ']' || c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// wrapPoint returns the offset just past the last break opportunity in
+// line that keeps it within width, or the first one beyond width when
+// there is none. It returns -1 if the line cannot be broken.
+func wrapPoint(line string, from, width int) int {
+        brk := -1
+        var quote byte
+        for i := from; i < len(line)-1; i++ {
+                c := line[i]
+                at := -1
+                switch {
+                case quote != 0:
+                        if c == '\\' {
+                                i++
+                        } else if c == quote {
+                                quote = 0
+                        }
+                case c == '"' || c == '\'':
+                        quote = c
+                case c == ',' && line[i+1] == ' ':
+                        at = i + 2
+                case (c == '&' || c == '|') && line[i+1] == c:
+                        at = i + 2
+                        i++
+                case strings.IndexByte(opChars, c) >= 0 && i > from && isOperandEnd(line[i-1]):
+                        // A run of operator characters is one token,
+                        // which must be a binary operator.
+                        j := i
+                        for j < len(line) && strings.IndexByte(opChars, line[j]) >= 0 {
+                                j++
+                        }
+                        if binaryOps[line[i:j]] {
+                                at = j
+                        }
+                        i = j - 1
+                }
+                if at < 0 {
+                        continue
+                }
+                if at > width && brk > 0 {
+                        break
+                }
+                brk = at
+        }
+        if brk >= len(line) {
+                return -1
+        }
+        return brk
+}
+
 func expr(n ast.Expr) string {
         p := new(Printer)
         VisitExpr(p, n)
         return p.String()
 }
 
+// ctypes maps predeclared Go types to C types and the headers that
+// declare them. int and uint are settled by -int-size in ctype.
+var ctypes = map[string][2]string{
+        "int8":       {"int8_t", "stdint.h"},
+        "int16":      {"int16_t", "stdint.h"},
+        "int32":      {"int32_t", "stdint.h"},
+        "int64":      {"int64_t", "stdint.h"},
+        "uint8":      {"uint8_t", "stdint.h"},
+        "uint16":     {"uint16_t", "stdint.h"},
+        "uint32":     {"uint32_t", "stdint.h"},
+        "uint64":     {"uint64_t", "stdint.h"},
+        "uintptr":    {"uintptr_t", "stdint.h"},
+        "byte":       {"uint8_t", "stdint.h"},
+        "rune":       {"int32_t", "stdint.h"},
+        "float32":    {"float", ""},
+        "float64":    {"double", ""},
+        "complex64":  {"float _Complex", ""},
+        "complex128": {"double _Complex", ""},
+        "bool":       {"bool", "stdbool.h"},
+        "string":     {"char*", ""},
+}
+
+// ctype returns the C spelling of the Go type name.
+func ctype(name string) string {
+        switch name {
+        case "int", "uint":
+                if *intSize == 0 {
+                        if name == "uint" {
+                                return "unsigned int"
+                        }
+                        return "int"
+                }
+                name = fmt.Sprintf("%s%d", name, *intSize)
+        }
+        t, ok := ctypes[name]
+        if !ok {
+                return name
+        }
+        if t[1] != "" {
+                include(t[1])
+        }
+        return t[0]
+}
+
 func typ(n ast.Expr) string {
         p := new(Printer)
         switch t := n.(type) {
         case *ast.Ident:
-                p.P(t.Name)
+                if t.Name == "error" && *errorOut && !wrappers["error"] {
+                        // An error is its message, NULL for none.
+                        wrappers["error"] = true
+                        pendingDecls = append(pendingDecls, "typedef const char* error;")
+                }
+                p.P("%s", ctype(t.Name))
         case *ast.StarExpr:
-                p.P("%s*", expr(t.X))
+                p.P("%s*", typ(t.X))
         case *ast.SelectorExpr:
                 p.P("%s.%s", expr(t.X), t.Sel.Name)
+        case nil:
+        default:
+                p.P("%s", unsupport(t))
         }
         return p.String()
 }
 
+// field renders the declaration of a parameter or struct field with at
+// most one name, or just its type if it has none.
 func field(n *ast.Field) string {
         p := new(Printer)
         if len(n.Names) == 0 {
-                p.P("%s", expr(n.Type))
+                p.P("%s", typ(n.Type))
+                return p.String()
+        }
+        if at, ok := n.Type.(*ast.ArrayType); ok && at.Len != nil {
+                elt, dims := arrayDims(at)
+                p.P("%s %s%s", typ(elt), n.Names[0].Name, dims)
                 return p.String()
         }
+        if ft, ok := n.Type.(*ast.FuncType); ok {
+                p.P("%s", funcPtr(ft, n.Names[0].Name))
+                return p.String()
+        }
+        // A pointer to an array binds the * to the name.
+        if st, ok := n.Type.(*ast.StarExpr); ok {
+                if at, ok := st.X.(*ast.ArrayType); ok && at.Len != nil {
+                        elt, dims := arrayDims(at)
+                        p.P("%s (*%s)%s", typ(elt), n.Names[0].Name, dims)
+                        return p.String()
+                }
+        }
         p.P("%s %s", typ(n.Type), n.Names[0].Name)
         return p.String()
 }
 
+// splitField returns a field for each of the names of n, which C
+// declares separately.
+func splitField(n *ast.Field) []*ast.Field {
+        if len(n.Names) <= 1 {
+                return []*ast.Field{n}
+        }
+        l := make([]*ast.Field, len(n.Names))
+        for i, name := range n.Names {
+                l[i] = &ast.Field{Doc: n.Doc, Names: []*ast.Ident{name}, Type: n.Type, Tag: n.Tag}
+        }
+        return l
+}
+
+// embeddedName returns the name Go gives an embedded field of type n.
+func embeddedName(n ast.Expr) string {
+        switch t := n.(type) {
+        case *ast.Ident:
+                return t.Name
+        case *ast.StarExpr:
+                return embeddedName(t.X)
+        case *ast.SelectorExpr:
+                return t.Sel.Name
+        }
+        return ""
+}
+
 func VisitBinExpr(p *Printer, n *ast.BinaryExpr) {
         VisitExpr(p, n.X)
-        p.P(n.Op.String())
+        p.P("%s", n.Op.String())
         VisitExpr(p, n.Y)
 }
 
 func VisitExpr(p *Printer, n ast.Expr) {
         switch t := n.(type) {
         case *ast.BasicLit:
+                if t.Kind == token.IMAG {
+                        include("complex.h")
+                        p.P("(%s*I)", strings.TrimSuffix(t.Value, "i"))
+                        return
+                }
+                if t.Kind == token.CHAR {
+                        p.P("%s", cchar(runeValue(t)))
+                        return
+                }
+                if t.Kind == token.STRING {
+                        v, err := strconv.Unquote(t.Value)
+                        if err != nil {
+                                fail(t.Pos(), "%v", err)
+                        }
+                        p.P("%s", cstring(v))
+                        return
+                }
                 p.P("%s", t.Value)
         case *ast.Ident:
-                p.P(t.Name)
+                if t.Name == "nil" {
+                        include("stddef.h")
+                        p.P("NULL")
+                        return
+                }
+                if t.Name == "iota" && iotaValue >= 0 {
+                        p.P("%d", iotaValue)
+                        return
+                }
+                p.P("%s", t.Name)
         case *ast.SelectorExpr:
+                x := t.X
+                for pe, ok := x.(*ast.ParenExpr); ok; pe, ok = x.(*ast.ParenExpr) {
+                        x = pe.X
+                }
+                // Fields are reached through pointers with ->, keeping
+                // the guard of an explicit dereference under -assert.
+                if star, ok := x.(*ast.StarExpr); ok && !*assertMode {
+                        p.P("%s->%s", expr(star.X), t.Sel.Name)
+                        return
+                }
+                if _, ok := exprType(t.X).(*ast.StarExpr); ok {
+                        if *assertMode && isSimple(t.X) {
+                                include("assert.h")
+                                include("stddef.h")
+                                p.P("(assert(%s != NULL), %s)->%s", expr(t.X), expr(t.X), t.Sel.Name)
+                                return
+                        }
+                        p.P("%s->%s", expr(t.X), t.Sel.Name)
+                        return
+                }
                 VisitExpr(p, t.X)
                 p.P(".%s", t.Sel.Name)
         case *ast.BinaryExpr:
                 p.P("(")
                 VisitBinExpr(p, t)
                 p.P(")")
+        case *ast.ParenExpr:
+                // Binary expressions bring their own parentheses.
+                switch t.X.(type) {
+                case *ast.BinaryExpr, *ast.ParenExpr:
+                        VisitExpr(p, t.X)
+                default:
+                        p.P("(")
+                        VisitExpr(p, t.X)
+                        p.P(")")
+                }
         case *ast.UnaryExpr:
-                p.P(t.Op.String())
+                p.P("%s", t.Op.String())
                 VisitExpr(p, t.X)
         case *ast.StarExpr:
                 p.P("*")
+                if *assertMode && isSimple(t.X) {
+                        include("assert.h")
+                        include("stddef.h")
+                        p.P("(assert(%s != NULL), %s)", expr(t.X), expr(t.X))
+                        return
+                }
                 VisitExpr(p, t.X)
         case *ast.IndexExpr:
-                VisitExpr(p, t.X)
+                // Go indexes a pointer to an array as the array.
+                if st, ok := exprType(t.X).(*ast.StarExpr); ok && isArray(st.X) {
+                        p.P("(*%s)", expr(t.X))
+                } else {
+                        VisitExpr(p, t.X)
+                }
+                if id, ok := t.X.(*ast.Ident); ok && wrapped[id.Name] {
+                        p.P(".a")
+                }
+                // Under -assert an index into an array of known length
+                // is checked against it.
+                if at, ok := exprType(t.X).(*ast.ArrayType); ok && at.Len != nil && *assertMode && isSimple(t.Index) {
+                        if n, ok := constInt(at.Len); ok {
+                                include("assert.h")
+                                i := expr(t.Index)
+                                p.P("[(assert(%s >= 0 && %s < %d), %s)]", i, i, n, i)
+                                return
+                        }
+                }
                 p.P("[")
                 VisitExpr(p, t.Index)
                 p.P("]")
         case *ast.CallExpr:
-                VisitExpr(p, t.Fun)
-                params := make([]string, 0)
-                for _, arg := range t.Args {
-                        params = append(params, expr(arg))
+                if fun, ok := t.Fun.(*ast.Ident); ok && fun.Name == "bool" && len(t.Args) == 1 && isNumConst(t.Args[0]) {
+                        fail(t.Pos(), "cannot convert %s to bool", expr(t.Args[0]))
+                }
+                if c, ok := charConst(t); ok {
+                        p.P("%s", c)
+                        return
+                }
+                // C cannot convert between struct types, so the fields
+                // are copied into a compound literal.
+                if id, ok := t.Fun.(*ast.Ident); ok && len(t.Args) == 1 && types[id.Name] != nil && vars[id.Name] == nil {
+                        if _, ok := resolve(id).(*ast.StructType); ok {
+                                if lit, ok := structCopy(id, t.Args[0]); ok {
+                                        VisitExpr(p, lit)
+                                        return
+                                }
+                                p.P("%s", unsupport(t))
+                                return
+                        }
+                }
+                if ct, ok := conversion(t); ok {
+                        arg := expr(t.Args[0])
+                        // C computes integers narrower than int in int,
+                        // and Go wraps them to their type before it
+                        // extends or truncates them.
+                        if at := exprType(t.Args[0]); at != nil && !isIdent(t.Args[0]) {
+                                if id, ok := resolve(at).(*ast.Ident); ok && (intWidth(id) == 8 || intWidth(id) == 16) {
+                                        arg = fmt.Sprintf("(%s)%s", typ(at), arg)
+                                }
+                        }
+                        p.P("(%s)%s", ct, arg)
+                        return
+                }
+                if fun, ok := t.Fun.(*ast.Ident); ok && fun.Name == "len" && len(t.Args) == 1 && vars["len"] == nil {
+                        // Go lengths are ints, not size_t. Only arrays
+                        // know theirs, slices and maps carry none in C.
+                        at := exprType(t.Args[0])
+                        switch rt := resolve(at).(type) {
+                        case *ast.ArrayType:
+                                if v, ok := constInt(rt.Len); ok && v == 0 {
+                                        p.P("(%s)(0)", ctype("int"))
+                                        return
+                                }
+                                if rt.Len != nil {
+                                        p.P("(%s)(%s)", ctype("int"), arrayLen(t.Args[0]))
+                                        return
+                                }
+                        case *ast.StarExpr:
+                                if pt, ok := resolve(rt.X).(*ast.ArrayType); ok && pt.Len != nil {
+                                        p.P("(%s)(%s)", ctype("int"), expr(pt.Len))
+                                        return
+                                }
+                        }
+                        if isString(at) {
+                                include("string.h")
+                                p.P("(%s)strlen(%s)", ctype("int"), expr(t.Args[0]))
+                                return
+                        }
+                        p.P("%s", unsupport(t))
+                        return
+                }
+                if c, ok := fmtCall(t); ok {
+                        p.P("%s", c)
+                        return
+                }
+                if sel, ok := t.Fun.(*ast.SelectorExpr); ok && *errorOut && expr(sel) == "errors.New" && len(t.Args) == 1 {
+                        VisitExpr(p, t.Args[0])
+                        return
                 }
-                p.P("(%s)", strings.Join(params, ", "))
+                // The results of a call returning several only go to
+                // the targets of an assignment or of a return.
+                if ft := callee(t.Fun); ft != nil && len(results(ft)) > 1 {
+                        p.P("%s", unsupport(t))
+                        return
+                }
+                fun, args := callArgs(t)
+                p.P("%s(%s)", fun, strings.Join(args, ", "))
+        case *ast.CompositeLit:
+                switch lt := resolve(t.Type).(type) {
+                case nil:
+                        // Nested literals may elide their type.
+                        p.P("%s", braces(t, nil))
+                case *ast.ArrayType:
+                        if lt.Len == nil {
+                                p.P("%s", unsupport(t))
+                                return
+                        }
+                        if zeroLength(lt) {
+                                lt = padded(lt)
+                        }
+                        // Braces alone only initialize, elsewhere the
+                        // array is a compound literal.
+                        requireStd(c99)
+                        elt, dims := arrayDims(lt)
+                        p.P("(%s%s)%s", typ(elt), dims, braces(t, nil))
+                case *ast.StructType:
+                        requireStd(c99)
+                        p.P("(%s)%s", structName(t.Type), braces(t, nil))
+                default:
+                        p.P("%s", unsupport(t))
+                }
+        case nil:
+        default:
+                p.P("%s", unsupport(t))
         }
 }
 
-func VisitStmt(p *Printer, n ast.Stmt) {
-        switch t := n.(type) {
-        case *ast.ExprStmt:
-                p.Pln("%s;", expr(t.X))
-        case *ast.AssignStmt:
-                p.Pln("%s %s %s;", expr(t.Lhs[0]), t.Tok.String(), expr(t.Rhs[0]))
-        case *ast.DeclStmt:
-                VisitDecl(p, t.Decl)
-        case *ast.ReturnStmt:
-                if len(t.Results) > 0 {
-                        p.Pln("return %s;", expr(t.Results[0]))
-                } else {
-                        p.Pln("return;")
+// braces renders the C brace initializer of a composite literal. elt
+// is the element type of the enclosing literal, used when n elides its
+// own type.
+func braces(n *ast.CompositeLit, elt ast.Expr) string {
+        t := n.Type
+        if t == nil {
+                t = elt
+        }
+        t = resolve(t)
+        if len(n.Elts) == 0 {
+                return "{0}"
+        }
+        var inner ast.Expr
+        if at, ok := t.(*ast.ArrayType); ok {
+                inner = at.Elt
+        }
+        elts := make([]string, 0, len(n.Elts))
+        for _, e := range n.Elts {
+                kv, ok := e.(*ast.KeyValueExpr)
+                if !ok {
+                        elts = append(elts, initializer(e, inner))
+                        continue
                 }
-        case *ast.IncDecStmt:
-                VisitExpr(p, t.X)
-                p.Pln("%s;", t.Tok.String())
-        case *ast.IfStmt:
-                p.Pi("if (")
-                VisitExpr(p, t.Cond)
-                p.P(") ")
-                VisitBlockStmt(p, t.Body)
-                if t.Else != nil {
-                        switch tt := t.Else.(type) {
-                        case *ast.IfStmt:
-                                p.Pi("else if(%s) ", expr(tt.Cond))
-                                VisitBlockStmt(p, tt.Body)
-                        case *ast.BlockStmt:
-                                p.Pi("else ")
-                                VisitBlockStmt(p, tt)
+                requireStd(c99)
+                key := fmt.Sprintf("[%s]", expr(kv.Key))
+                switch t.(type) {
+                case *ast.StructType:
+                        key = "." + expr(kv.Key)
+                case nil:
+                        // Without a type, an identifier key names a field.
+                        if _, ok := kv.Key.(*ast.Ident); ok {
+                                key = "." + expr(kv.Key)
                         }
                 }
-        case *ast.ForStmt:
-                pp := new(Printer)
-                VisitStmt(pp, t.Init)
-                init := strings.TrimRight(pp.String(), ";\n")
-                pp.Reset()
-
-                VisitStmt(pp, t.Post)
-                post := strings.TrimRight(pp.String(), ";\n")
-
-                p.Pi("for (%s; %s; %s) ", init, expr(t.Cond), post)
-                VisitBlockStmt(p, t.Body)
+                elts = append(elts, fmt.Sprintf("%s = %s", key, initializer(kv.Value, inner)))
         }
+        return "{" + strings.Join(elts, ", ") + "}"
 }
 
-func VisitBlockStmt(p *Printer, n *ast.BlockStmt) {
-        p.P("{\n")
-        p.Indent()
-        for _, elem := range n.List {
-                VisitStmt(p, elem)
+// zeroLength reports whether t has a zero-length dimension, which ISO C
+// arrays cannot have.
+func zeroLength(t *ast.ArrayType) bool {
+        for {
+                switch l := t.Len.(type) {
+                case *ast.BasicLit:
+                        if l.Value == "0" {
+                                return true
+                        }
+                case *ast.Ident:
+                        if v, ok := consts[l.Name]; ok && v == 0 {
+                                return true
+                        }
+                }
+                at, ok := t.Elt.(*ast.ArrayType)
+                if !ok || at.Len == nil {
+                        return false
+                }
+                t = at
         }
-        p.Unindent()
-        p.Pln("}")
 }
 
-func VisitFunction(p *Printer, n *ast.FuncDecl) {
-        fun := n.Type
-        funcname := n.Name.Name
-        rettyp := "void"
-        if fun.Results.NumFields() > 0 {
-                rettyp = typ(fun.Results.List[0].Type)
+// initializer renders n as the initializer of a declaration or of an
+// element of type elt, where composite literals need no compound type.
+func initializer(n ast.Expr, elt ast.Expr) string {
+        if lit, ok := n.(*ast.CompositeLit); ok {
+                switch resolve(lit.Type).(type) {
+                case *ast.ArrayType, *ast.StructType, nil:
+                        return braces(lit, elt)
+                }
         }
+        return expr(n)
+}
 
-        params := ""
-        if fun.Params.NumFields() != 0 {
-                paraml := make([]string, 0)
-                for _, f := range fun.Params.List {
-                        param := field(f)
-                        paraml = append(paraml, param)
+// cstring quotes s as a C string literal. Bytes outside printable
+// ASCII are written as hex escapes, and the literal is split when a hex
+// digit follows one, since C hex escapes have no length limit.
+func cstring(s string) string {
+        var b strings.Builder
+        b.WriteByte('"')
+        hex := false
+        for i := 0; i < len(s); i++ {
+                c := s[i]
+                if hex && strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+                        b.WriteString(`" "`)
+                }
+                hex = false
+                switch c {
+                case '"', '\\':
+                        b.WriteByte('\\')
+                        b.WriteByte(c)
+                case '\n':
+                        b.WriteString(`\n`)
+                case '\t':
+                        b.WriteString(`\t`)
+                case '\r':
+                        b.WriteString(`\r`)
+                case '?':
+                        // Keep ?? from starting a trigraph.
+                        if i > 0 && s[i-1] == '?' {
+                                b.WriteByte('\\')
+                        }
+                        b.WriteByte(c)
+                default:
+                        if c < 0x20 || c > 0x7e {
+                                fmt.Fprintf(&b, `\x%02x`, c)
+                                hex = true
+                        } else {
+                                b.WriteByte(c)
+                        }
                 }
-                params = strings.Join(paraml, ", ")
         }
+        b.WriteByte('"')
+        return b.String()
+}
 
-        p.Pln("%s %s(%s)", rettyp, funcname, params)
-        VisitBlockStmt(p, n.Body)
+// fmtCall renders a call to a function of package fmt as the stdio
+// call doing the same, if there is one.
+func fmtCall(n *ast.CallExpr) (string, bool) {
+        sel, ok := n.Fun.(*ast.SelectorExpr)
+        if !ok || expr(sel.X) != "fmt" {
+                return "", false
+        }
+        switch sel.Sel.Name {
+        case "Scan", "Scanln":
+                verbs := make([]string, len(n.Args))
+                for i := range n.Args {
+                        verbs[i] = "%v"
+                }
+                return scanf(n, strings.Join(verbs, " "), n.Args)
+        case "Print", "Println":
+                // Println separates all operands, Print those that are
+                // not strings.
+                var format strings.Builder
+                args := make([]ast.Expr, 0, len(n.Args))
+                for i, arg := range n.Args {
+                        str := isString(exprType(arg))
+                        if i > 0 && (sel.Sel.Name == "Println" || !str && !isString(exprType(n.Args[i-1]))) {
+                                format.WriteByte(' ')
+                        }
+                        if lit, ok := arg.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+                                s, _ := strconv.Unquote(lit.Value)
+                                format.WriteString(strings.ReplaceAll(s, "%", "%%"))
+                                continue
+                        }
+                        format.WriteString("%v")
+                        args = append(args, arg)
+                }
+                if sel.Sel.Name == "Println" {
+                        format.WriteByte('\n')
+                }
+                return printf(n, format.String(), args)
+        case "Printf":
+                lit, ok := n.Args[0].(*ast.BasicLit)
+                if !ok || lit.Kind != token.STRING {
+                        return "", false
+                }
+                format, _ := strconv.Unquote(lit.Value)
+                return printf(n, format, n.Args[1:])
+        case "Scanf":
+                lit, ok := n.Args[0].(*ast.BasicLit)
+                if !ok || lit.Kind != token.STRING {
+                        return "", false
+                }
+                format, _ := strconv.Unquote(lit.Value)
+                return scanf(n, format, n.Args[1:])
+        }
+        return "", false
 }
 
-func VisitSpec(p *Printer, n ast.Spec) {
-        switch d := n.(type) {
-        case *ast.ValueSpec:
-                switch t := d.Type.(type) {
-                case *ast.ArrayType:
-                        p.Pln("%s %s[%s];", expr(t.Elt), d.Names[0].Name, expr(t.Len))
-                case *ast.StarExpr:
-                        p.Pln("%s* %s;", expr(t.X), d.Names[0].Name)
-                default:
-                        p.Pln("%s %s;", expr(d.Type), d.Names[0].Name)
+// printf renders a printf call writing args with the Go format string
+// format. Booleans are written as words.
+func printf(n *ast.CallExpr, format string, args []ast.Expr) (string, bool) {
+        types := make([]ast.Expr, len(args))
+        l := []string{""}
+        for i, arg := range args {
+                types[i] = exprType(arg)
+                if id, ok := types[i].(*ast.Ident); ok && id.Name == "bool" {
+                        l = append(l, fmt.Sprintf(`%s ? "true" : "false"`, expr(arg)))
+                        continue
                 }
-        case *ast.ImportSpec:
-                path, _ := strconv.Unquote(d.Path.Value)
-                p.Pln(`#include <%s.h>`, path)
-        case *ast.TypeSpec:
-                switch t := d.Type.(type) {
-                case *ast.Ident:
-                        p.Pln("typedef %s %s;", t.Name, d.Name)
-                case *ast.StructType:
-                        p.Pln("struct %s {", d.Name)
-                        p.Indent()
-                        for _, f := range t.Fields.List {
-                                p.Pln("%s;", field(f))
+                // Constants and promoted arithmetic need the C type the
+                // verb was chosen for, which a conversion already casts to.
+                if call, ok := arg.(*ast.CallExpr); ok {
+                        if ct, ok := conversion(call); ok && ct == typ(types[i]) {
+                                l = append(l, expr(arg))
+                                continue
                         }
-                        p.Unindent()
-                        p.Pln("};")
                 }
+                if id, ok := resolve(types[i]).(*ast.Ident); ok && intWidth(id) > 0 && (!isSimple(arg) || isConst(arg)) && typ(types[i]) != "int" {
+                        l = append(l, fmt.Sprintf("(%s)%s", typ(types[i]), expr(arg)))
+                        continue
+                }
+                l = append(l, expr(arg))
+        }
+        f, ok := cformat(format, types, false)
+        if !ok {
+                return unsupport(n), true
         }
+        l[0] = f
+        include("stdio.h")
+        return fmt.Sprintf("printf(%s)", strings.Join(l, ", ")), true
 }
 
-func VisitDecl(p *Printer, n ast.Decl) {
-        switch d := n.(type) {
-        case *ast.FuncDecl:
-                VisitFunction(p, d)
-        case *ast.GenDecl:
-                VisitSpec(p, d.Specs[0])
-        default:
-                log.Fatalf("unsupport declear type %p", d)
+// scanf renders a scanf call reading args, which are pointers, with the
+// Go format string format.
+func scanf(n *ast.CallExpr, format string, args []ast.Expr) (string, bool) {
+        types := make([]ast.Expr, len(args))
+        for i, arg := range args {
+                if pt, ok := exprType(arg).(*ast.StarExpr); ok {
+                        types[i] = pt.X
+                }
+        }
+        f, ok := cformat(format, types, true)
+        if !ok {
+                return unsupport(n), true
         }
+        include("stdio.h")
+        l := []string{f}
+        for _, arg := range args {
+                l = append(l, expr(arg))
+        }
+        return fmt.Sprintf("scanf(%s)", strings.Join(l, ", ")), true
 }
 
-func VisitFile(p *Printer, n *ast.File) {
-        for _, decl := range n.Decls {
-                VisitDecl(p, decl)
+// cformat converts a Go format string for values of the given types to
+// a C string literal, or reports false if a verb has no C equivalent.
+// Conversions of fixed-width integers use the inttypes.h macros.
+func cformat(format string, types []ast.Expr, scan bool) (string, bool) {
+        var b strings.Builder
+        arg := 0
+        for i := 0; i < len(format); i++ {
+                if format[i] != '%' {
+                        b.WriteByte(format[i])
+                        continue
+                }
+                j := i + 1
+                for j < len(format) && strings.IndexByte("+-# 0123456789.", format[j]) >= 0 {
+                        j++
+                }
+                if j == len(format) {
+                        return "", false
+                }
+                if format[j] == '%' {
+                        b.WriteString("%%")
+                        i = j
+                        continue
+                }
+                if arg >= len(types) {
+                        return "", false
+                }
+                v, ok := cverb(format[j], types[arg], scan)
+                if !ok {
+                        return "", false
+                }
+                b.WriteString(format[i:j] + v)
+                arg++
+                i = j
+        }
+        // Macros are spliced between the literal pieces around them.
+        parts := strings.Split(b.String(), "\x00")
+        l := make([]string, 0, len(parts))
+        for i, part := range parts {
+                switch {
+                case i%2 == 1:
+                        l = append(l, part)
+                case part != "" || len(parts) == 1:
+                        l = append(l, cstring(part))
+                }
         }
+        return strings.Join(l, " "), true
 }
 
-func main() {
-        flag.Parse()
-        if flag.NArg() < 1 {
-                log.Fatal("missing source file")
+// cverb returns the C conversion of the Go verb v for a value of type
+// t, without the leading %. Macros are set off by NUL bytes.
+func cverb(v byte, t ast.Expr, scan bool) (string, bool) {
+        if _, ok := resolve(t).(*ast.StarExpr); ok && !scan && (v == 'v' || v == 'p') {
+                return "p", true
         }
-        src := flag.Args()[0]
-        fset := token.NewFileSet()
-        f, err := parser.ParseFile(fset, src, nil, 0)
-        if err != nil {
-                log.Fatal(err)
+        id, ok := resolve(t).(*ast.Ident)
+        if !ok {
+                return "", false
+        }
+        name := id.Name
+        switch {
+        case intWidth(id) > 0:
+                unsigned := strings.HasPrefix(name, "u") || name == "byte"
+                switch v {
+                case 'v', 'd':
+                        v = 'd'
+                        if unsigned {
+                                v = 'u'
+                        }
+                case 'x', 'X', 'o':
+                case 'c':
+                        if !scan {
+                                return "c", true
+                        }
+                        return "", false
+                default:
+                        return "", false
+                }
+                if (name == "int" || name == "uint") && *intSize == 0 {
+                        return string(v), true
+                }
+                include("inttypes.h")
+                if name == "uintptr" {
+                        return fmt.Sprintf("\x00%sPTR\x00", map[bool]string{true: "SCN", false: "PRI"}[scan]+string(v)), true
+                }
+                macro := "PRI"
+                if scan {
+                        macro = "SCN"
+                }
+                return fmt.Sprintf("\x00%s%c%d\x00", macro, v, intWidth(id)), true
+        case name == "float32" || name == "float64":
+                switch v {
+                case 'v':
+                        v = 'g'
+                case 'e', 'E', 'f', 'F', 'g', 'G':
+                default:
+                        return "", false
+                }
+                if scan && name == "float64" {
+                        return "l" + string(v), true
+                }
+                return string(v), true
+        case name == "string" && !scan && (v == 'v' || v == 's'):
+                return "s", true
+        case name == "bool" && !scan && (v == 'v' || v == 't'):
+                return "s", true
         }
-        if *printAST {
-                ast.Print(fset, f)
+        return "", false
+}
+
+// isSimple reports whether n can be evaluated more than once without
+// side effects.
+func isSimple(n ast.Expr) bool {
+        switch t := n.(type) {
+        case *ast.Ident:
+                return true
+        case *ast.SelectorExpr:
+                return isSimple(t.X)
+        case *ast.ParenExpr:
+                return isSimple(t.X)
         }
-        p := NewPrinter()
-        VisitFile(p, f)
-        p.WriteTo(os.Stdout)
+        return false
+}
+
+// isNumConst reports whether n is a numeric literal, possibly signed or
+// parenthesized.
+func isNumConst(n ast.Expr) bool {
+        switch t := n.(type) {
+        case *ast.BasicLit:
+                return t.Kind != token.STRING
+        case *ast.UnaryExpr:
+                return (t.Op == token.SUB || t.Op == token.ADD) && isNumConst(t.X)
+        case *ast.ParenExpr:
+                return isNumConst(t.X)
+        }
+        return false
+}
+
+// isNumeric reports whether t is an integer, floating-point or complex
+// type.
+func isNumeric(t ast.Expr) bool {
+        id, ok := resolve(t).(*ast.Ident)
+        if !ok {
+                return false
+        }
+        switch id.Name {
+        case "float32", "float64", "complex64", "complex128":
+                return true
+        }
+        return intWidth(id) > 0
+}
+
+// isConst reports whether n names an integer constant folded so far.
+func isConst(n ast.Expr) bool {
+        id, ok := n.(*ast.Ident)
+        if !ok || vars[id.Name] != nil {
+                return false
+        }
+        _, ok = consts[id.Name]
+        return ok
+}
+
+// structCopy returns the composite literal of struct type to copying
+// the fields of v, if the struct type of v has the same fields.
+func structCopy(to *ast.Ident, v ast.Expr) (*ast.CompositeLit, bool) {
+        // v is read once for each field.
+        if !isSimple(v) {
+                return nil, false
+        }
+        from, ok := exprType(v).(*ast.Ident)
+        if !ok || types[from.Name] == nil {
+                return nil, false
+        }
+        fields := func(t *ast.Ident) []*ast.Field {
+                st, ok := resolve(t).(*ast.StructType)
+                if !ok {
+                        return nil
+                }
+                var l []*ast.Field
+                for _, f := range st.Fields.List {
+                        if len(f.Names) == 0 {
+                                f = &ast.Field{Names: []*ast.Ident{ast.NewIdent(embeddedName(f.Type))}, Type: f.Type}
+                        }
+                        l = append(l, splitField(f)...)
+                }
+                return l
+        }
+        dst, src := fields(to), fields(from)
+        if dst == nil || len(dst) != len(src) {
+                return nil, false
+        }
+        lit := &ast.CompositeLit{Type: to}
+        for i, f := range dst {
+                name := f.Names[0].Name
+                if src[i].Names[0].Name != name || typ(src[i].Type) != typ(f.Type) || isArray(f.Type) {
+                        return nil, false
+                }
+                lit.Elts = append(lit.Elts, &ast.KeyValueExpr{Key: ast.NewIdent(name), Value: &ast.SelectorExpr{X: v, Sel: ast.NewIdent(name)}})
+        }
+        return lit, true
+}
+
+// conversion returns the C type n converts its operand to, if n is a
+// conversion to a scalar type that a C cast performs.
+func conversion(n *ast.CallExpr) (string, bool) {
+        id, ok := n.Fun.(*ast.Ident)
+        if !ok || len(n.Args) != 1 || vars[id.Name] != nil || funcs[id.Name] != nil {
+                return "", false
+        }
+        if _, ok := types[id.Name]; !ok && ctypes[id.Name][0] == "" && id.Name != "int" && id.Name != "uint" {
+                return "", false
+        }
+        switch t := resolve(id).(type) {
+        case *ast.Ident:
+                if t.Name == "string" {
+                        return "", false
+                }
+                // Go has no conversion from numbers to bool.
+                if t.Name == "bool" && isNumeric(exprType(n.Args[0])) {
+                        fail(n.Pos(), "cannot convert %s to bool", expr(n.Args[0]))
+                }
+        case *ast.StarExpr:
+        default:
+                return "", false
+        }
+        return typ(id), true
+}
+
+// charConst renders byte(c) of a printable ASCII constant as a C char
+// literal, so character tables read as characters rather than codes.
+func charConst(n *ast.CallExpr) (string, bool) {
+        fun, ok := n.Fun.(*ast.Ident)
+        if !ok || fun.Name != "byte" || len(n.Args) != 1 {
+                return "", false
+        }
+        lit, ok := n.Args[0].(*ast.BasicLit)
+        if !ok {
+                return "", false
+        }
+        var v int64
+        switch lit.Kind {
+        case token.CHAR:
+                v = int64(runeValue(lit))
+        case token.INT:
+                var err error
+                if v, err = strconv.ParseInt(lit.Value, 0, 64); err != nil {
+                        return "", false
+                }
+        default:
+                return "", false
+        }
+        if v < 0x20 || v > 0x7e {
+                return "", false
+        }
+        return cchar(rune(v)), true
+}
+
+// runeValue returns the code point of a rune literal.
+func runeValue(lit *ast.BasicLit) rune {
+        r, _, _, err := strconv.UnquoteChar(lit.Value[1:len(lit.Value)-1], '\'')
+        if err != nil {
+                fail(lit.Pos(), "%v", err)
+        }
+        return r
+}
+
+// cchar renders r as a C character constant if it is ASCII, and as its
+// integer code point otherwise.
+func cchar(r rune) string {
+        switch {
+        case r > 0x7f:
+                return strconv.Itoa(int(r))
+        case r == '\'' || r == '\\':
+                return fmt.Sprintf("'\\%c'", r)
+        case r == '\n':
+                return `'\n'`
+        case r == '\t':
+                return `'\t'`
+        case r == '\r':
+                return `'\r'`
+        case r == 0:
+                return `'\0'`
+        case r < 0x20 || r == 0x7f:
+                return fmt.Sprintf(`'\x%02x'`, r)
+        }
+        return fmt.Sprintf("'%c'", r)
+}
+
+func VisitStmt(p *Printer, n ast.Stmt) {
+        if n != nil && n.Pos().IsValid() {
+                emitAsm(p, n.Pos())
+        }
+        switch t := n.(type) {
+        case *ast.ExprStmt:
+                // The discarded results of a call still need somewhere
+                // to go.
+                if call, ok := t.X.(*ast.CallExpr); ok {
+                        call = spread(p, call)
+                        t = &ast.ExprStmt{X: call}
+                        if ft := callee(call.Fun); ft != nil && len(results(ft)) > 1 {
+                                lhs := make([]ast.Expr, len(results(ft)))
+                                for i := range lhs {
+                                        lhs[i] = ast.NewIdent("_")
+                                }
+                                VisitAssignStmt(p, &ast.AssignStmt{Lhs: lhs, Tok: token.ASSIGN, Rhs: []ast.Expr{call}})
+                                return
+                        }
+                }
+                p.Pln("%s;", expr(t.X))
+        case *ast.AssignStmt:
+                if len(t.Lhs) == 1 && len(t.Rhs) == 1 {
+                        if call, ok := t.Rhs[0].(*ast.CallExpr); ok {
+                                a := *t
+                                a.Rhs = []ast.Expr{spread(p, call)}
+                                t = &a
+                        }
+                }
+                VisitAssignStmt(p, t)
+        case *ast.DeclStmt:
+                VisitDecl(p, t.Decl)
+        case *ast.ReturnStmt:
+                if len(t.Results) == 1 {
+                        if call, ok := t.Results[0].(*ast.CallExpr); ok {
+                                r := *t
+                                r.Results = []ast.Expr{spread(p, call)}
+                                t = &r
+                        }
+                }
+                VisitReturnStmt(p, t)
+        case *ast.IncDecStmt:
+                p.Pln("%s%s;", expr(t.X), t.Tok.String())
+        case *ast.IfStmt:
+                p.Pi("if (")
+                VisitExpr(p, t.Cond)
+                p.P(") ")
+                VisitBlockStmt(p, t.Body)
+                if t.Else != nil {
+                        switch tt := t.Else.(type) {
+                        case *ast.IfStmt:
+                                p.Pi("else if(%s) ", expr(tt.Cond))
+                                VisitBlockStmt(p, tt.Body)
+                        case *ast.BlockStmt:
+                                p.Pi("else ")
+                                VisitBlockStmt(p, tt)
+                        }
+                }
+        case *ast.ForStmt:
+                defer openScope()()
+                switch {
+                case t.Init == nil && t.Cond == nil && t.Post == nil:
+                        p.Pi("for (;;) ")
+                case t.Init == nil && t.Post == nil:
+                        cond := expr(t.Cond)
+                        // A binary condition already has its parentheses.
+                        if _, ok := t.Cond.(*ast.BinaryExpr); ok {
+                                p.Pi("while %s ", cond)
+                        } else {
+                                p.Pi("while (%s) ", cond)
+                        }
+                default:
+                        p.Pi("for (%s; %s; %s) ", forClause(t.Init), expr(t.Cond), forClause(t.Post))
+                }
+                defer leave(enter(""))
+                VisitBlockStmt(p, t.Body)
+        case *ast.DeferStmt:
+                // Only defers run by every path through the body.
+                if t != topStmt {
+                        p.Pln("%s", unsupport(t))
+                        return
+                }
+                call, ok := snapshot(p, t.Call)
+                if !ok {
+                        p.Pln("%s", unsupport(t))
+                        return
+                }
+                defers = append(defers, call)
+        case *ast.BlockStmt:
+                p.Pi("")
+                VisitBlockStmt(p, t)
+        case *ast.EmptyStmt:
+        case *ast.SwitchStmt:
+                VisitSwitchStmt(p, t)
+        case *ast.RangeStmt:
+                VisitRangeStmt(p, t)
+        case *ast.LabeledStmt:
+                name := t.Label.Name
+                if branches(curFunc.Body, token.GOTO, name) {
+                        // A C label cannot precede a declaration.
+                        if declares([]ast.Stmt{t.Stmt}) {
+                                p.Pln("%s:;", name)
+                        } else {
+                                p.Pln("%s:", name)
+                        }
+                }
+                switch s := t.Stmt.(type) {
+                case *ast.BlockStmt:
+                        // C cannot break out of a block, so jump past it.
+                        blockLabels[name] = true
+                        p.Pi("")
+                        VisitBlockStmt(p, s)
+                        p.Pln("%s_end:;", name)
+                case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt:
+                        // C break and continue take no label, so jump
+                        // past the loop or to the end of its body.
+                        blockLabels[name] = true
+                        if branches(s, token.CONTINUE, name) {
+                                cont := &ast.LabeledStmt{Label: ast.NewIdent(name + "_continue"), Stmt: &ast.EmptyStmt{}}
+                                switch l := s.(type) {
+                                case *ast.ForStmt:
+                                        c := *l
+                                        c.Body = &ast.BlockStmt{List: append(l.Body.List[:len(l.Body.List):len(l.Body.List)], cont)}
+                                        s = &c
+                                case *ast.RangeStmt:
+                                        c := *l
+                                        c.Body = &ast.BlockStmt{List: append(l.Body.List[:len(l.Body.List):len(l.Body.List)], cont)}
+                                        s = &c
+                                }
+                        }
+                        VisitStmt(p, s)
+                        if branches(s, token.BREAK, name) {
+                                p.Pln("%s_end:;", name)
+                        }
+                case *ast.EmptyStmt:
+                        if !branches(curFunc.Body, token.GOTO, name) {
+                                p.Pln("%s:;", name)
+                        }
+                default:
+                        VisitStmt(p, s)
+                }
+        case *ast.BranchStmt:
+                switch {
+                case t.Tok == token.BREAK && t.Label != nil && blockLabels[t.Label.Name]:
+                        p.Pln("goto %s_end;", t.Label.Name)
+                case t.Tok == token.CONTINUE && t.Label != nil && blockLabels[t.Label.Name]:
+                        p.Pln("goto %s_continue;", t.Label.Name)
+                case t.Tok == token.BREAK && t.Label == nil:
+                        if len(breaks) > 0 && breaks[len(breaks)-1].label != "" {
+                                b := breaks[len(breaks)-1]
+                                b.used = true
+                                p.Pln("goto %s;", b.label)
+                                return
+                        }
+                        p.Pln("break;")
+                case t.Tok == token.CONTINUE && t.Label == nil:
+                        p.Pln("continue;")
+                case t.Tok == token.GOTO:
+                        p.Pln("goto %s;", t.Label.Name)
+                default:
+                        p.Pln("%s", unsupport(t))
+                }
+        case nil:
+        default:
+                p.Pln("%s", unsupport(t))
+        }
+}
+
+// runDefers emits the calls deferred by curFunc once, last first, and
+// then its return. A return jumps to the label before the last call
+// deferred when it is reached.
+func runDefers(p *Printer) {
+        for i := len(defers) - 1; i >= 0; i-- {
+                if cleanups[i+1] {
+                        p.Pln("%s:", cleanupLabel(i+1))
+                }
+                p.Pln("%s;", expr(defers[i]))
+        }
+        res := results(curFunc.Type)
+        switch {
+        case isEntry(curFunc):
+                p.Pln("return 0;")
+        case len(res) == 1 || errorResult(curFunc.Type):
+                r := res[0].name
+                if r == "" {
+                        r = "_ret"
+                }
+                p.Pln("return %s;", r)
+        default:
+                p.Pln("return;")
+        }
+}
+
+// snapshot evaluates the arguments of the deferred call n into
+// temporaries, as Go does at the defer, and returns n called with
+// them. A pointer argument is copied, not what it points to. It
+// reports false if an argument has no type a temporary can take.
+func snapshot(p *Printer, n *ast.CallExpr) (*ast.CallExpr, bool) {
+        var params []ast.Expr
+        if ft := callee(n.Fun); ft != nil {
+                params = paramTypes(ft)
+        }
+        types := make([]ast.Expr, len(n.Args))
+        for i, a := range n.Args {
+                if _, ok := a.(*ast.BasicLit); ok || isNumConst(a) || isConst(a) {
+                        continue
+                }
+                if i < len(params) {
+                        types[i] = params[i]
+                } else {
+                        types[i] = exprType(a)
+                }
+                if _, ok := types[i].(*ast.Ellipsis); ok || types[i] == nil || isArray(types[i]) {
+                        return nil, false
+                }
+        }
+        c := *n
+        c.Args = make([]ast.Expr, len(n.Args))
+        for i, a := range n.Args {
+                c.Args[i] = a
+                if types[i] != nil {
+                        id := ast.NewIdent(fmt.Sprintf("_d%d_%d", len(defers), i))
+                        define(p, id, types[i], a)
+                        c.Args[i] = id
+                }
+        }
+        return &c, true
+}
+
+// cleanup returns the label a return of curFunc jumps to, which runs
+// the calls deferred so far.
+func cleanup() string {
+        cleanups[len(defers)] = true
+        return cleanupLabel(len(defers))
+}
+
+// cleanupLabel names the label before the last of the first n deferred
+// calls of curFunc. The one before them all is cleanup.
+func cleanupLabel(n int) string {
+        total := 0
+        for _, s := range curFunc.Body.List {
+                if _, ok := s.(*ast.DeferStmt); ok {
+                        total++
+                }
+        }
+        if n == total {
+                return "cleanup"
+        }
+        return fmt.Sprintf("cleanup%d", n)
+}
+
+// hasDefer reports whether n defers a call.
+func hasDefer(n ast.Node) bool {
+        found := false
+        ast.Inspect(n, func(n ast.Node) bool {
+                if _, ok := n.(*ast.DeferStmt); ok {
+                        found = true
+                }
+                return !found
+        })
+        return found
+}
+
+// breakTarget is a statement an unlabeled break leaves: a C loop or
+// switch if label is empty, or else the statement ending at label.
+type breakTarget struct {
+        label string
+        used  bool
+}
+
+// enter makes a statement ending at label, or a C loop or switch if
+// label is empty, the target of unlabeled breaks until leave.
+func enter(label string) *breakTarget {
+        b := &breakTarget{label: label}
+        breaks = append(breaks, b)
+        return b
+}
+
+func leave(b *breakTarget) {
+        breaks = breaks[:len(breaks)-1]
+}
+
+// branches reports whether n holds a branch statement tok to label.
+func branches(n ast.Node, tok token.Token, label string) bool {
+        found := false
+        ast.Inspect(n, func(n ast.Node) bool {
+                if b, ok := n.(*ast.BranchStmt); ok && b.Tok == tok && b.Label != nil && b.Label.Name == label {
+                        found = true
+                }
+                return !found
+        })
+        return found
+}
+
+// VisitRangeStmt emits a range over an array as a counting loop, the
+// value being copied out of the array at the start of each iteration.
+// The length of an array of unknown type is taken with sizeof.
+func VisitRangeStmt(p *Printer, n *ast.RangeStmt) {
+        defer openScope()()
+        if t := exprType(n.X); t != nil && isIntType(t) {
+                rangeInt(p, n, t)
+                return
+        }
+        if _, ok := constInt(n.X); ok {
+                rangeInt(p, n, ast.NewIdent("int"))
+                return
+        }
+        // The array is indexed once per iteration instead of evaluated
+        // once.
+        if hasCall(n.X) {
+                p.Pln("%s", unsupport(n))
+                return
+        }
+        t := exprType(n.X)
+        at, _ := t.(*ast.ArrayType)
+        value := n.Value != nil && !isBlank(n.Value)
+        if t != nil && (at == nil || at.Len == nil) || value && at == nil {
+                p.Pln("%s", unsupport(n))
+                return
+        }
+        rangeDepth++
+        defer func() { rangeDepth-- }()
+        idx := fmt.Sprintf("_i%d", rangeDepth-1)
+        // An assigned index is left at the last element, not past it.
+        if n.Key != nil && !isBlank(n.Key) && n.Tok == token.DEFINE {
+                idx = n.Key.(*ast.Ident).Name
+        }
+        elem := &ast.IndexExpr{X: n.X, Index: ast.NewIdent(idx)}
+        length := arrayLen(n.X)
+        if at != nil {
+                length = expr(at.Len)
+        }
+        declare(idx, ast.NewIdent("int"))
+        requireStd(c99)
+        defer leave(enter(""))
+        p.Pi("for (int %s = 0; %s < %s; %s++) {\n", idx, idx, length, idx)
+        p.Indent()
+        if n.Tok == token.ASSIGN && n.Key != nil && !isBlank(n.Key) {
+                p.Pln("%s = %s;", expr(n.Key), idx)
+        }
+        if value {
+                v := n.Value.(*ast.Ident).Name
+                if n.Tok == token.DEFINE {
+                        declare(v, at.Elt)
+                }
+                switch {
+                case isArray(at.Elt):
+                        // C arrays cannot be assigned.
+                        if n.Tok == token.DEFINE {
+                                elt, dims := arrayDims(at.Elt.(*ast.ArrayType))
+                                p.Pln("%s %s%s;", typ(elt), v, dims)
+                        }
+                        include("string.h")
+                        p.Pln("memcpy(%s, %s, sizeof %s);", v, expr(elem), v)
+                case n.Tok == token.DEFINE:
+                        VisitSpec(p, &ast.ValueSpec{Names: []*ast.Ident{n.Value.(*ast.Ident)}, Type: at.Elt, Values: []ast.Expr{elem}})
+                default:
+                        p.Pln("%s = %s;", v, expr(elem))
+                }
+        }
+        for _, s := range n.Body.List {
+                VisitStmt(p, s)
+        }
+        emitAsm(p, n.Body.Rbrace)
+        p.Unindent()
+        p.Pln("}")
+}
+
+// rangeInt emits a range over an integer n of type t, which counts
+// from 0 to n-1.
+func rangeInt(p *Printer, n *ast.RangeStmt, t ast.Expr) {
+        rangeDepth++
+        defer func() { rangeDepth-- }()
+        idx := fmt.Sprintf("_i%d", rangeDepth-1)
+        if n.Key != nil && !isBlank(n.Key) && n.Tok == token.DEFINE {
+                idx = n.Key.(*ast.Ident).Name
+        }
+        declare(idx, t)
+        // The count is evaluated once, and the body may change the
+        // variables it reads.
+        count := expr(n.X)
+        init := ""
+        if _, ok := constInt(n.X); !ok {
+                init = fmt.Sprintf(", _n%d = %s", rangeDepth-1, count)
+                count = fmt.Sprintf("_n%d", rangeDepth-1)
+        }
+        requireStd(c99)
+        defer leave(enter(""))
+        p.Pi("for (%s %s = 0%s; %s < %s; %s++) {\n", typ(t), idx, init, idx, count, idx)
+        p.Indent()
+        if n.Tok == token.ASSIGN && n.Key != nil && !isBlank(n.Key) {
+                p.Pln("%s = %s;", expr(n.Key), idx)
+        }
+        for _, s := range n.Body.List {
+                VisitStmt(p, s)
+        }
+        emitAsm(p, n.Body.Rbrace)
+        p.Unindent()
+        p.Pln("}")
+}
+
+// arrayLen returns the number of elements of array n, computed with
+// sizeof.
+func arrayLen(n ast.Expr) string {
+        elems := expr(n)
+        if id, ok := n.(*ast.Ident); ok && wrapped[id.Name] {
+                elems += ".a"
+        }
+        return fmt.Sprintf("sizeof(%s)/sizeof(%s)", elems, expr(&ast.IndexExpr{X: n, Index: ast.NewIdent("0")}))
+}
+
+// hasCall reports whether n calls a function.
+func hasCall(n ast.Node) bool {
+        found := false
+        ast.Inspect(n, func(n ast.Node) bool {
+                if _, ok := n.(*ast.CallExpr); ok {
+                        found = true
+                }
+                return !found
+        })
+        return found
+}
+
+// VisitSwitchStmt emits a switch. Go cases do not fall through, so each
+// clause ends in a break unless it ends in fallthrough. A switch without
+// a tag, or with cases that are not integer constants, becomes an
+// if/else chain.
+func VisitSwitchStmt(p *Printer, n *ast.SwitchStmt) {
+        defer openScope()()
+        if n.Init != nil {
+                p.Pln("{")
+                p.Indent()
+                VisitStmt(p, n.Init)
+                defer func() {
+                        p.Unindent()
+                        p.Pln("}")
+                }()
+        }
+        if *exhaustive {
+                checkExhaustive(n)
+        }
+        if n.Tag == nil || !constCases(n.Body) {
+                switchChain(p, n)
+                return
+        }
+        if vals, ok := dense(n.Body); ok && *computedGoto && isSimple(n.Tag) {
+                // Other compilers get the plain switch.
+                p.P("#if defined(__GNUC__)\n")
+                before := len(unsupported)
+                jumpTable(p, n, vals)
+                unsupported = unsupported[:before]
+                p.P("#else\n")
+                caseSwitch(p, n)
+                p.P("#endif\n")
+                return
+        }
+        caseSwitch(p, n)
+}
+
+// caseSwitch emits a switch whose cases are integer constants as a C
+// switch.
+func caseSwitch(p *Printer, n *ast.SwitchStmt) {
+        p.Pln("switch (%s) {", expr(n.Tag))
+        defer leave(enter(""))
+        for _, s := range n.Body.List {
+                c := s.(*ast.CaseClause)
+                if c.List == nil {
+                        p.Pln("default:")
+                }
+                for _, e := range c.List {
+                        p.Pln("case %s:", expr(e))
+                }
+                body := c.Body
+                fall := len(body) > 0 && isFallthrough(body[len(body)-1])
+                if fall {
+                        body = body[:len(body)-1]
+                }
+                if !fall && (len(body) == 0 || !isJump(body[len(body)-1])) {
+                        body = append(body[:len(body):len(body)], &ast.BranchStmt{Tok: token.BREAK})
+                }
+                p.Indent()
+                // A label cannot precede a declaration, whose scope
+                // would also span the later cases.
+                if declares(body) {
+                        p.Pi("")
+                        VisitBlockStmt(p, &ast.BlockStmt{List: body})
+                } else {
+                        for _, s := range body {
+                                VisitStmt(p, s)
+                        }
+                }
+                p.Unindent()
+        }
+        p.Pln("}")
+}
+
+// checkExhaustive warns if the switch n on a value of an enum type has
+// no default and its cases miss some of the constants of the type.
+func checkExhaustive(n *ast.SwitchStmt) {
+        id, ok := exprType(n.Tag).(*ast.Ident)
+        if !ok || enumConsts[id.Name] == nil {
+                return
+        }
+        handled := make(map[int64]bool)
+        for _, s := range n.Body.List {
+                c := s.(*ast.CaseClause)
+                if c.List == nil {
+                        return
+                }
+                for _, e := range c.List {
+                        v, ok := constInt(e)
+                        if !ok {
+                                return
+                        }
+                        handled[v] = true
+                }
+        }
+        missing := make([]string, 0)
+        for _, m := range enumConsts[id.Name] {
+                if !handled[consts[m]] {
+                        missing = append(missing, m)
+                }
+        }
+        if len(missing) > 0 {
+                log.Printf("%s: warning: switch on %s misses %s", fset.Position(n.Pos()), id.Name, strings.Join(missing, ", "))
+        }
+}
+
+// dense returns the values of the cases of a switch body, by clause,
+// if there are at least 3 of them and they span at most twice as many
+// integers.
+func dense(n *ast.BlockStmt) ([][]int64, bool) {
+        vals := make([][]int64, len(n.List))
+        count, min, max := 0, int64(0), int64(0)
+        for i, s := range n.List {
+                for _, e := range s.(*ast.CaseClause).List {
+                        v, ok := constInt(e)
+                        if !ok {
+                                return nil, false
+                        }
+                        if count == 0 || v < min {
+                                min = v
+                        }
+                        if count == 0 || v > max {
+                                max = v
+                        }
+                        vals[i] = append(vals[i], v)
+                        count++
+                }
+        }
+        return vals, count >= 3 && max-min < int64(2*count)
+}
+
+// jumpTable emits a switch whose clauses have the case values vals as
+// a computed goto through a table of the addresses of their labels,
+// with the GNU labels as values extension.
+func jumpTable(p *Printer, n *ast.SwitchStmt, vals [][]int64) {
+        labelCount++
+        name := fmt.Sprintf("_sw%d", labelCount)
+        b := enter(name + "_end")
+        defer leave(b)
+        target := make(map[int64]string)
+        min, max := int64(0), int64(0)
+        def := b.label
+        for i, s := range n.Body.List {
+                if s.(*ast.CaseClause).List == nil {
+                        def = fmt.Sprintf("%s_%d", name, i)
+                }
+                for _, v := range vals[i] {
+                        if len(target) == 0 || v < min {
+                                min = v
+                        }
+                        if len(target) == 0 || v > max {
+                                max = v
+                        }
+                        target[v] = fmt.Sprintf("%s_%d", name, i)
+                }
+        }
+        table := make([]string, 0, max-min+1)
+        for v := min; v <= max; v++ {
+                l, ok := target[v]
+                if !ok {
+                        l = def
+                }
+                table = append(table, "&&"+l)
+        }
+        tag := expr(n.Tag)
+        p.Pln("{")
+        p.Indent()
+        p.Pln("static void* %s_table[] = {%s};", name, strings.Join(table, ", "))
+        p.Pln("if (%s < %d || %s > %d) {", tag, min, tag, max)
+        p.Indent()
+        p.Pln("goto %s;", def)
+        p.Unindent()
+        p.Pln("}")
+        if min == 0 {
+                p.Pln("goto *%s_table[%s];", name, tag)
+        } else {
+                p.Pln("goto *%s_table[%s - %d];", name, tag, min)
+        }
+        for i, s := range n.Body.List {
+                c := s.(*ast.CaseClause)
+                p.Pln("%s_%d:;", name, i)
+                body := c.Body
+                fall := len(body) > 0 && isFallthrough(body[len(body)-1])
+                if fall {
+                        body = body[:len(body)-1]
+                }
+                if !fall && (len(body) == 0 || !isJump(body[len(body)-1])) {
+                        body = append(body[:len(body):len(body)], &ast.BranchStmt{Tok: token.BREAK})
+                }
+                p.Indent()
+                if declares(body) {
+                        p.Pi("")
+                        VisitBlockStmt(p, &ast.BlockStmt{List: body})
+                } else {
+                        for _, s := range body {
+                                VisitStmt(p, s)
+                        }
+                }
+                p.Unindent()
+        }
+        p.Unindent()
+        p.Pln("}")
+        if b.used || def == b.label {
+                p.Pln("%s:;", b.label)
+        }
+}
+
+// switchChain emits a switch as an if/else chain, the default clause
+// coming last whatever its position.
+func switchChain(p *Printer, n *ast.SwitchStmt) {
+        tag := ""
+        if n.Tag != nil {
+                tag = expr(n.Tag)
+                if !isSimple(n.Tag) {
+                        // Evaluate the tag once.
+                        t := exprType(n.Tag)
+                        if t == nil {
+                                p.Pln("%s", unsupport(n))
+                                return
+                        }
+                        p.Pln("{")
+                        p.Indent()
+                        p.Pln("%s _tag = %s;", typ(t), tag)
+                        tag = "_tag"
+                        defer func() {
+                                p.Unindent()
+                                p.Pln("}")
+                        }()
+                }
+        }
+        str := n.Tag != nil && isString(exprType(n.Tag))
+        // A break leaves the chain, not the loop around it.
+        labelCount++
+        b := enter(fmt.Sprintf("_sw%d_end", labelCount))
+        defer leave(b)
+        var def *ast.CaseClause
+        nconds := 0
+        for _, s := range n.Body.List {
+                c := s.(*ast.CaseClause)
+                if len(c.Body) > 0 && isFallthrough(c.Body[len(c.Body)-1]) {
+                        p.Pln("%s", unsupport(c.Body[len(c.Body)-1]))
+                        return
+                }
+                if c.List == nil {
+                        def = c
+                        continue
+                }
+                conds := make([]string, 0, len(c.List))
+                for _, e := range c.List {
+                        switch {
+                        case n.Tag == nil:
+                                conds = append(conds, expr(e))
+                        case str:
+                                include("string.h")
+                                conds = append(conds, fmt.Sprintf("strcmp(%s, %s) == 0", tag, expr(e)))
+                        default:
+                                conds = append(conds, fmt.Sprintf("%s == %s", tag, expr(e)))
+                        }
+                }
+                if nconds == 0 {
+                        p.Pi("if (%s) ", strings.Join(conds, " || "))
+                } else {
+                        p.Pi("else if (%s) ", strings.Join(conds, " || "))
+                }
+                VisitBlockStmt(p, &ast.BlockStmt{List: c.Body})
+                nconds++
+        }
+        if def != nil {
+                if nconds == 0 {
+                        p.Pi("")
+                } else {
+                        p.Pi("else ")
+                }
+                VisitBlockStmt(p, &ast.BlockStmt{List: def.Body})
+        }
+        if b.used {
+                p.Pln("%s:;", b.label)
+        }
+}
+
+// constCases reports whether every case of a switch body is an integer
+// constant, and so can be a C case label.
+func constCases(n *ast.BlockStmt) bool {
+        for _, s := range n.List {
+                for _, e := range s.(*ast.CaseClause).List {
+                        if _, ok := constInt(e); !ok {
+                                return false
+                        }
+                }
+        }
+        return true
+}
+
+func isFallthrough(n ast.Stmt) bool {
+        b, ok := n.(*ast.BranchStmt)
+        return ok && b.Tok == token.FALLTHROUGH
+}
+
+// isJump reports whether n always leaves the statement it ends.
+func isJump(n ast.Stmt) bool {
+        switch n.(type) {
+        case *ast.ReturnStmt, *ast.BranchStmt:
+                return true
+        }
+        return false
+}
+
+// declares reports whether l declares a variable at its top level.
+func declares(l []ast.Stmt) bool {
+        for _, s := range l {
+                switch t := s.(type) {
+                case *ast.DeclStmt:
+                        return true
+                case *ast.AssignStmt:
+                        if t.Tok == token.DEFINE {
+                                return true
+                        }
+                }
+        }
+        return false
+}
+
+func isString(n ast.Expr) bool {
+        id, ok := n.(*ast.Ident)
+        return ok && id.Name == "string"
+}
+
+// forClause renders the init or post statement of a for loop. A
+// multi-variable assignment becomes a comma-separated list, which is
+// evaluated left to right rather than simultaneously, so one whose
+// values read an earlier target is unsupported.
+func forClause(n ast.Stmt) string {
+        if a, ok := n.(*ast.AssignStmt); ok && len(a.Lhs) > 1 && len(a.Lhs) == len(a.Rhs) && clashes(a) {
+                return unsupport(a)
+        }
+        if a, ok := n.(*ast.AssignStmt); ok && a.Tok == token.DEFINE && len(a.Lhs) > 1 && len(a.Lhs) == len(a.Rhs) {
+                // One C declaration can only introduce variables of a
+                // single type.
+                t := ""
+                l := make([]string, 0, len(a.Lhs))
+                for i := range a.Lhs {
+                        vt := defineType(a.Lhs[i].(*ast.Ident), a.Rhs[i])
+                        if vt == nil {
+                                vt = ast.NewIdent(*defaultType)
+                        }
+                        if t != "" && typ(vt) != t {
+                                return unsupport(a)
+                        }
+                        t = typ(vt)
+                        declare(a.Lhs[i].(*ast.Ident).Name, vt)
+                        l = append(l, fmt.Sprintf("%s = %s", expr(a.Lhs[i]), expr(a.Rhs[i])))
+                }
+                requireStd(c99)
+                return t + " " + strings.Join(l, ", ")
+        }
+        if a, ok := n.(*ast.AssignStmt); ok && len(a.Lhs) > 1 && len(a.Lhs) == len(a.Rhs) {
+                l := make([]string, 0, len(a.Lhs))
+                for i := range a.Lhs {
+                        l = append(l, fmt.Sprintf("%s %s %s", expr(a.Lhs[i]), a.Tok.String(), expr(a.Rhs[i])))
+                }
+                return strings.Join(l, ", ")
+        }
+        pp := new(Printer)
+        VisitStmt(pp, n)
+        return strings.TrimRight(pp.String(), ";\n")
+}
+
+// assignArray renders the assignment of value to lhs, an array of type
+// at, which C arrays do not support: a wrapped array is assigned a
+// wrapper, and a plain one has the elements copied in.
+func assignArray(lhs, value ast.Expr, at *ast.ArrayType) string {
+        if id, ok := lhs.(*ast.Ident); ok && wrapped[id.Name] {
+                return fmt.Sprintf("%s = %s", id.Name, wrapValue(value, at))
+        }
+        src := expr(value)
+        if id, ok := value.(*ast.Ident); ok && wrapped[id.Name] {
+                src = id.Name + ".a"
+        }
+        if call, ok := value.(*ast.CallExpr); ok && isArray(exprType(call)) {
+                // The array of a returned wrapper lives until the end of
+                // the full expression.
+                requireStd(c11)
+                src = expr(call) + ".a"
+        }
+        include("string.h")
+        l := expr(lhs)
+        return fmt.Sprintf("memcpy(%s, %s, sizeof %s)", l, src, l)
+}
+
+// spread emits f of the call g(f()) into temporaries when f gives one
+// result for each parameter of g, and returns g called with them. Other
+// calls are returned as they are.
+func spread(p *Printer, call *ast.CallExpr) *ast.CallExpr {
+        if len(call.Args) != 1 {
+                return call
+        }
+        inner, ok := call.Args[0].(*ast.CallExpr)
+        if !ok {
+                return call
+        }
+        ft, gt := callee(inner.Fun), callee(call.Fun)
+        if ft == nil || gt == nil || len(results(ft)) < 2 || len(results(ft)) != gt.Params.NumFields() {
+                return call
+        }
+        spreadCount++
+        vals := make([]ast.Expr, len(results(ft)))
+        for i := range vals {
+                vals[i] = ast.NewIdent(fmt.Sprintf("_a%d_%d", spreadCount, i))
+        }
+        VisitAssignStmt(p, &ast.AssignStmt{Lhs: vals, Tok: token.DEFINE, Rhs: []ast.Expr{inner}})
+        c := *call
+        c.Args = vals
+        return &c
+}
+
+// tailCall returns the call of return vals if it alone gives the
+// several results res of curFunc.
+func tailCall(vals []ast.Expr, res []result) (*ast.CallExpr, bool) {
+        if len(vals) != 1 || len(res) < 2 {
+                return nil, false
+        }
+        call, ok := vals[0].(*ast.CallExpr)
+        if !ok {
+                return nil, false
+        }
+        ft := callee(call.Fun)
+        if ft == nil || len(results(ft)) != len(res) || errorResult(ft) != errorResult(curFunc.Type) {
+                return nil, false
+        }
+        return call, true
+}
+
+// VisitAssignStmt emits an assignment. Go assigns all targets at once,
+// so when a value reads a target assigned before it the values are
+// first saved in temporaries. Assigning the results of a call passes
+// the targets as its out-parameters.
+func VisitAssignStmt(p *Printer, n *ast.AssignStmt) {
+        if len(n.Lhs) > 1 && len(n.Rhs) == 1 {
+                call, ok := n.Rhs[0].(*ast.CallExpr)
+                if !ok {
+                        p.Pln("%s", unsupport(n))
+                        return
+                }
+                var res []result
+                if ft := callee(call.Fun); ft != nil {
+                        res = results(ft)
+                }
+                if n.Tok == token.DEFINE {
+                        for i, l := range n.Lhs {
+                                id := l.(*ast.Ident)
+                                if isBlank(id) || scope[id.Name] {
+                                        continue
+                                }
+                                var t ast.Expr
+                                if len(res) == len(n.Lhs) {
+                                        t = res[i].typ
+                                }
+                                define(p, id, t, nil)
+                        }
+                }
+                fun, args := callArgs(call)
+                ft := callee(call.Fun)
+                errOut := ft != nil && errorResult(ft) && len(n.Lhs) == 2
+                // Discarded results still need somewhere to go.
+                temps := make([]string, 0)
+                for i, l := range n.Lhs {
+                        // The value is returned.
+                        if errOut && i == 0 {
+                                continue
+                        }
+                        if !isBlank(l) {
+                                args = append(args, "&"+expr(l))
+                                continue
+                        }
+                        if len(res) != len(n.Lhs) {
+                                p.Pln("%s", unsupport(n))
+                                return
+                        }
+                        temps = append(temps, fmt.Sprintf("%s _t%d;", typ(res[i].typ), i))
+                        args = append(args, fmt.Sprintf("&_t%d", i))
+                }
+                if len(temps) > 0 {
+                        p.Pln("{")
+                        p.Indent()
+                        for _, t := range temps {
+                                p.Pln("%s", t)
+                        }
+                }
+                if errOut && !isBlank(n.Lhs[0]) {
+                        p.Pln("%s = %s(%s);", expr(n.Lhs[0]), fun, strings.Join(args, ", "))
+                } else {
+                        p.Pln("%s(%s);", fun, strings.Join(args, ", "))
+                }
+                if len(temps) > 0 {
+                        p.Unindent()
+                        p.Pln("}")
+                }
+                return
+        }
+        if len(n.Lhs) != len(n.Rhs) {
+                p.Pln("%s", unsupport(n))
+                return
+        }
+
+        if !clashes(n) {
+                for i := range n.Lhs {
+                        if isBlank(n.Lhs[i]) {
+                                // Nothing is left to mark used.
+                                if id, ok := n.Rhs[i].(*ast.Ident); !ok || !dropped[id.Name] {
+                                        p.Pln("(void)%s;", expr(n.Rhs[i]))
+                                }
+                                continue
+                        }
+                        // := declares the variables it does not reuse.
+                        if id, ok := n.Lhs[i].(*ast.Ident); ok && n.Tok == token.DEFINE && !scope[id.Name] {
+                                define(p, id, defineType(id, n.Rhs[i]), n.Rhs[i])
+                                continue
+                        }
+                        if at, ok := exprType(n.Lhs[i]).(*ast.ArrayType); ok && at.Len != nil && n.Tok == token.ASSIGN {
+                                p.Pln("%s;", assignArray(n.Lhs[i], n.Rhs[i], at))
+                                continue
+                        }
+                        p.Pln("%s %s %s;", expr(n.Lhs[i]), assignOp(n.Tok), expr(n.Rhs[i]))
+                }
+                return
+        }
+        // The temporaries need the type of their target, and arrays
+        // cannot be copied by assignment.
+        types := make([]ast.Expr, len(n.Lhs))
+        for i, l := range n.Lhs {
+                if isBlank(l) {
+                        continue
+                }
+                types[i] = exprType(l)
+                if id, ok := l.(*ast.Ident); ok && n.Tok == token.DEFINE && !scope[id.Name] {
+                        types[i] = defineType(id, n.Rhs[i])
+                }
+                if types[i] == nil || isArray(types[i]) {
+                        p.Pln("%s", unsupport(n))
+                        return
+                }
+        }
+        // := declares the variables it does not reuse up front, which
+        // would hide those of the same name the values read.
+        if n.Tok == token.DEFINE {
+                fresh := make(map[string]bool)
+                for _, l := range n.Lhs {
+                        if id := l.(*ast.Ident); !isBlank(id) && !scope[id.Name] {
+                                fresh[id.Name] = true
+                        }
+                }
+                for _, v := range n.Rhs {
+                        if reads(v, fresh) {
+                                p.Pln("%s", unsupport(n))
+                                return
+                        }
+                }
+                for i, l := range n.Lhs {
+                        if fresh[l.(*ast.Ident).Name] {
+                                define(p, l.(*ast.Ident), types[i], nil)
+                        }
+                }
+        }
+        // Evaluate every target address and value before assigning.
+        p.Pln("{")
+        p.Indent()
+        for i := range n.Lhs {
+                if isBlank(n.Lhs[i]) {
+                        p.Pln("(void)%s;", expr(n.Rhs[i]))
+                        continue
+                }
+                l := expr(n.Lhs[i])
+                t := typ(types[i])
+                if !isIdent(n.Lhs[i]) {
+                        p.Pln("%s* _p%d = &%s;", t, i, l)
+                }
+                p.Pln("%s _t%d = %s;", t, i, expr(n.Rhs[i]))
+        }
+        for i, l := range n.Lhs {
+                switch {
+                case isBlank(l):
+                case isIdent(l):
+                        p.Pln("%s = _t%d;", expr(l), i)
+                default:
+                        p.Pln("*_p%d = _t%d;", i, i)
+                }
+        }
+        p.Unindent()
+        p.Pln("}")
+}
+
+// clashes reports whether a value or target of the assignment n reads
+// a variable assigned before it, which must see the old value.
+func clashes(n *ast.AssignStmt) bool {
+        assigned := make(map[string]bool)
+        for i := range n.Lhs {
+                if reads(n.Rhs[i], assigned) || reads(n.Lhs[i], assigned) {
+                        return true
+                }
+                if id := root(n.Lhs[i]); id != nil && !isBlank(id) {
+                        assigned[id.Name] = true
+                }
+        }
+        return false
+}
+
+// exprType returns the Go type of n as far as it can be told from the
+// declarations in the file, or nil if it cannot.
+func exprType(n ast.Expr) ast.Expr {
+        switch t := n.(type) {
+        case *ast.Ident:
+                if v, ok := vars[t.Name]; ok {
+                        return v
+                }
+                if v, ok := globals[t.Name]; ok {
+                        return v
+                }
+                // A function used as a value is a function pointer.
+                if f, ok := funcs[t.Name]; ok {
+                        return f.Type
+                }
+                switch t.Name {
+                case "true", "false":
+                        return ast.NewIdent("bool")
+                }
+        case *ast.BasicLit:
+                switch t.Kind {
+                case token.INT:
+                        return ast.NewIdent("int")
+                case token.FLOAT:
+                        return ast.NewIdent("float64")
+                case token.IMAG:
+                        return ast.NewIdent("complex128")
+                case token.CHAR:
+                        return ast.NewIdent("rune")
+                case token.STRING:
+                        return ast.NewIdent("string")
+                }
+        case *ast.ParenExpr:
+                return exprType(t.X)
+        case *ast.StarExpr:
+                if pt, ok := exprType(t.X).(*ast.StarExpr); ok {
+                        return pt.X
+                }
+        case *ast.UnaryExpr:
+                if t.Op == token.AND {
+                        if x := exprType(t.X); x != nil {
+                                return &ast.StarExpr{X: x}
+                        }
+                        return nil
+                }
+                return exprType(t.X)
+        case *ast.IndexExpr:
+                switch xt := resolve(exprType(t.X)).(type) {
+                case *ast.ArrayType:
+                        return xt.Elt
+                case *ast.StarExpr:
+                        if at, ok := xt.X.(*ast.ArrayType); ok {
+                                return at.Elt
+                        }
+                case *ast.Ident:
+                        if xt.Name == "string" {
+                                return ast.NewIdent("byte")
+                        }
+                }
+        case *ast.SelectorExpr:
+                xt := exprType(t.X)
+                if pt, ok := xt.(*ast.StarExpr); ok {
+                        xt = pt.X
+                }
+                if id, ok := xt.(*ast.Ident); ok && types[id.Name] != nil {
+                        if st, ok := resolve(id).(*ast.StructType); ok {
+                                for _, f := range st.Fields.List {
+                                        if len(f.Names) == 0 && embeddedName(f.Type) == t.Sel.Name {
+                                                return f.Type
+                                        }
+                                        for _, name := range f.Names {
+                                                if name.Name == t.Sel.Name {
+                                                        return f.Type
+                                                }
+                                        }
+                                }
+                        }
+                }
+        case *ast.CompositeLit:
+                return t.Type
+        case *ast.CallExpr:
+                if ft := callee(t.Fun); ft != nil {
+                        if res := results(ft); len(res) == 1 {
+                                return res[0].typ
+                        }
+                        return nil
+                }
+                if id, ok := t.Fun.(*ast.Ident); ok && vars[id.Name] == nil {
+                        if id.Name == "len" {
+                                return ast.NewIdent("int")
+                        }
+                        // A conversion to a declared or predeclared type.
+                        if _, ok := types[id.Name]; ok || ctypes[id.Name][0] != "" || id.Name == "int" || id.Name == "uint" {
+                                return id
+                        }
+                }
+        case *ast.BinaryExpr:
+                switch t.Op {
+                case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+                        return ast.NewIdent("bool")
+                case token.SHL, token.SHR:
+                        return exprType(t.X)
+                }
+                // An untyped constant operand takes the other's type.
+                if _, ok := t.X.(*ast.BasicLit); ok {
+                        if y := exprType(t.Y); y != nil {
+                                return y
+                        }
+                }
+                return exprType(t.X)
+        }
+        return nil
+}
+
+// root returns the variable an assignment target is part of, or nil.
+func root(n ast.Expr) *ast.Ident {
+        switch t := n.(type) {
+        case *ast.Ident:
+                return t
+        case *ast.IndexExpr:
+                return root(t.X)
+        case *ast.SelectorExpr:
+                return root(t.X)
+        case *ast.StarExpr:
+                return root(t.X)
+        case *ast.ParenExpr:
+                return root(t.X)
+        }
+        return nil
+}
+
+func isIdent(n ast.Expr) bool {
+        _, ok := n.(*ast.Ident)
+        return ok
+}
+
+// assignOp returns the C operator for an assignment token; := of a
+// variable already declared is a plain assignment.
+func assignOp(tok token.Token) string {
+        if tok == token.DEFINE {
+                return "="
+        }
+        return tok.String()
+}
+
+// defineType returns the type of a variable declared by name := value,
+// or nil if it cannot be inferred.
+func defineType(name *ast.Ident, value ast.Expr) ast.Expr {
+        return exprType(value)
+}
+
+// assumed warns that the type of name could not be inferred and
+// returns the -default-type it is given instead.
+func assumed(p *Printer, name *ast.Ident) ast.Expr {
+        log.Printf("%s: warning: cannot infer the type of %s, assuming %s", fset.Position(name.Pos()), name.Name, *defaultType)
+        p.Pln("/* goc: type of %s not inferred, assuming %s */", name.Name, *defaultType)
+        return ast.NewIdent(*defaultType)
+}
+
+// define declares name of type t, initialized to value if it is not
+// nil. A nil t is -default-type, flagged in the output.
+func define(p *Printer, name *ast.Ident, t ast.Expr, value ast.Expr) {
+        if t == nil {
+                t = assumed(p, name)
+        }
+        // Declarations may follow statements, and open for clauses.
+        requireStd(c99)
+        if call, ok := value.(*ast.CallExpr); ok && isArray(t) {
+                declare(name.Name, t)
+                wrapped[name.Name] = true
+                p.Pln("%s %s = %s;", wrapperType(t.(*ast.ArrayType)), name.Name, expr(call))
+                return
+        }
+        spec := &ast.ValueSpec{Names: []*ast.Ident{name}, Type: t}
+        if value != nil {
+                spec.Values = []ast.Expr{value}
+        }
+        VisitSpec(p, spec)
+}
+
+// declare records a local of curFunc declared in the current block.
+func declare(name string, t ast.Expr) {
+        vars[name] = t
+        scope[name] = true
+        delete(dropped, name)
+}
+
+// openScope starts a block, in which a declaration shadows the locals
+// of the enclosing ones. Calling the function it returns ends it.
+func openScope() func() {
+        saved, savedScope := vars, scope
+        vars = make(map[string]ast.Expr, len(saved))
+        for k, v := range saved {
+                vars[k] = v
+        }
+        scope = make(map[string]bool)
+        return func() {
+                vars, scope = saved, savedScope
+        }
+}
+
+// isBlank reports whether n is the blank identifier.
+func isBlank(n ast.Expr) bool {
+        id, ok := n.(*ast.Ident)
+        return ok && id.Name == "_"
+}
+
+// reads reports whether n mentions any of the named variables.
+func reads(n ast.Node, names map[string]bool) bool {
+        found := false
+        ast.Inspect(n, func(n ast.Node) bool {
+                if id, ok := n.(*ast.Ident); ok && names[id.Name] {
+                        found = true
+                }
+                return !found
+        })
+        return found
+}
+
+// VisitReturnStmt emits a return from curFunc. Results beyond one are
+// stored through the function's out-parameters, and a bare return of
+// named results returns the named locals.
+func VisitReturnStmt(p *Printer, n *ast.ReturnStmt) {
+        if isEntry(curFunc) {
+                if len(defers) > 0 {
+                        p.Pln("goto %s;", cleanup())
+                        return
+                }
+                p.Pln("return 0;")
+                return
+        }
+        res := results(curFunc.Type)
+        vals := n.Results
+        if len(vals) == 0 && len(res) > 0 && res[0].name != "" {
+                for _, r := range res {
+                        vals = append(vals, ast.NewIdent(r.name))
+                }
+        }
+        // Returning a call with as many results passes the out-parameters
+        // of curFunc on to it.
+        if call, ok := tailCall(vals, res); ok {
+                fun, args := callArgs(call)
+                if !errorResult(curFunc.Type) {
+                        for i := range res {
+                                args = append(args, fmt.Sprintf("ret%d", i))
+                        }
+                        p.Pln("%s(%s);", fun, strings.Join(args, ", "))
+                        if len(defers) > 0 {
+                                p.Pln("goto %s;", cleanup())
+                                return
+                        }
+                        p.Pln("return;")
+                        return
+                }
+                c := fmt.Sprintf("%s(%s)", fun, strings.Join(append(args, "err"), ", "))
+                if len(defers) == 0 {
+                        p.Pln("return %s;", c)
+                        return
+                }
+                r := res[0].name
+                if r == "" {
+                        r = "_ret"
+                }
+                p.Pln("%s = %s;", r, c)
+                p.Pln("goto %s;", cleanup())
+                return
+        }
+        value := func(i int) string {
+                if at, ok := res[i].typ.(*ast.ArrayType); ok && at.Len != nil {
+                        return wrapValue(vals[i], at)
+                }
+                return expr(vals[i])
+        }
+        if len(defers) > 0 {
+                // The results are set before the deferred calls run.
+                switch {
+                case len(vals) == 0:
+                case errorResult(curFunc.Type) && len(vals) == 2:
+                        p.Pln("*err = %s;", expr(vals[1]))
+                        fallthrough
+                case len(res) == 1:
+                        r := res[0].name
+                        if r == "" {
+                                r = "_ret"
+                        }
+                        if v := value(0); v != r {
+                                p.Pln("%s = %s;", r, v)
+                        }
+                case len(res) > 1 && len(vals) == len(res):
+                        for i := range vals {
+                                p.Pln("*ret%d = %s;", i, value(i))
+                        }
+                }
+                p.Pln("goto %s;", cleanup())
+                return
+        }
+        switch {
+        case errorResult(curFunc.Type) && len(vals) == 2:
+                p.Pln("*err = %s;", expr(vals[1]))
+                p.Pln("return %s;", value(0))
+        case len(res) > 1 && len(vals) == len(res):
+                for i := range vals {
+                        p.Pln("*ret%d = %s;", i, value(i))
+                }
+                p.Pln("return;")
+        case len(vals) > 0 && len(res) == 1:
+                p.Pln("return %s;", value(0))
+        case len(vals) > 0:
+                p.Pln("return %s;", expr(vals[0]))
+        default:
+                p.Pln("return;")
+        }
+}
+
+func VisitBlockStmt(p *Printer, n *ast.BlockStmt) {
+        if len(n.List) == 0 {
+                p.P("{}\n")
+                return
+        }
+        defer openScope()()
+        p.P("{\n")
+        p.Indent()
+        for _, elem := range n.List {
+                VisitStmt(p, elem)
+        }
+        if n.Rbrace.IsValid() {
+                emitAsm(p, n.Rbrace)
+        }
+        p.Unindent()
+        p.Pln("}")
+}
+
+// errorResult reports whether ft returns (T, error) and -error-out
+// passes the error through an out-parameter.
+func errorResult(ft *ast.FuncType) bool {
+        res := results(ft)
+        if !*errorOut || len(res) != 2 {
+                return false
+        }
+        id, ok := res[1].typ.(*ast.Ident)
+        return ok && id.Name == "error"
+}
+
+// callArgs returns the C function a call goes to and its arguments. A
+// method call passes its receiver first.
+func callArgs(n *ast.CallExpr) (string, []string) {
+        fun := expr(n.Fun)
+        args := make([]string, 0, len(n.Args)+1)
+        if m, x := method(n.Fun); m != nil {
+                recv, ptr := receiver(m)
+                fun = recv + "_" + m.Name.Name
+                _, isPtr := exprType(x).(*ast.StarExpr)
+                switch {
+                case ptr && !isPtr:
+                        args = append(args, "&"+expr(x))
+                case !ptr && isPtr:
+                        args = append(args, "*"+expr(x))
+                default:
+                        args = append(args, expr(x))
+                }
+        }
+        var ptypes []ast.Expr
+        if ft := callee(n.Fun); ft != nil {
+                ptypes = paramTypes(ft)
+        }
+        for i, arg := range n.Args {
+                if i < len(ptypes) && isArray(ptypes[i]) {
+                        args = append(args, wrapValue(arg, ptypes[i].(*ast.ArrayType)))
+                        continue
+                }
+                args = append(args, expr(arg))
+        }
+        return fun, args
+}
+
+// method returns the method of the file a call through fun goes to,
+// and the receiver it is called on, or nil if it is not one. A method
+// promoted from an embedded field is called on that field, the
+// shallowest one first as in Go.
+func method(fun ast.Expr) (*ast.FuncDecl, ast.Expr) {
+        sel, ok := fun.(*ast.SelectorExpr)
+        if !ok {
+                return nil, nil
+        }
+        level := []ast.Expr{sel.X}
+        for depth := 0; len(level) > 0 && depth <= len(types); depth++ {
+                var next []ast.Expr
+                for _, x := range level {
+                        t := exprType(x)
+                        if pt, ok := t.(*ast.StarExpr); ok {
+                                t = pt.X
+                        }
+                        id, ok := t.(*ast.Ident)
+                        if !ok {
+                                continue
+                        }
+                        if m := methods[id.Name+"."+sel.Sel.Name]; m != nil {
+                                return m, x
+                        }
+                        if st, ok := resolve(id).(*ast.StructType); ok && types[id.Name] != nil {
+                                for _, f := range st.Fields.List {
+                                        if len(f.Names) == 0 {
+                                                next = append(next, &ast.SelectorExpr{X: x, Sel: ast.NewIdent(embeddedName(f.Type))})
+                                        }
+                                }
+                        }
+                }
+                level = next
+        }
+        return nil, nil
+}
+
+// receiver returns the name of the receiver type of method n, and
+// whether the receiver is a pointer.
+func receiver(n *ast.FuncDecl) (string, bool) {
+        t := n.Recv.List[0].Type
+        pt, ptr := t.(*ast.StarExpr)
+        if ptr {
+                t = pt.X
+        }
+        return embeddedName(t), ptr
+}
+
+// callee returns the type of the function called through fun, or nil
+// if it is unknown. Locals shadow the functions of the file.
+func callee(fun ast.Expr) *ast.FuncType {
+        if m, _ := method(fun); m != nil {
+                return m.Type
+        }
+        id, ok := fun.(*ast.Ident)
+        if !ok {
+                return nil
+        }
+        if t, ok := vars[id.Name]; ok {
+                ft, _ := t.(*ast.FuncType)
+                return ft
+        }
+        if f, ok := funcs[id.Name]; ok {
+                return f.Type
+        }
+        return nil
+}
+
+// paramFields returns the parameters of ft, one field per name.
+func paramFields(ft *ast.FuncType) []*ast.Field {
+        l := make([]*ast.Field, 0)
+        for _, f := range ft.Params.List {
+                l = append(l, splitField(f)...)
+        }
+        return l
+}
+
+// paramTypes lists the parameter types of ft, one per parameter.
+func paramTypes(ft *ast.FuncType) []ast.Expr {
+        l := make([]ast.Expr, 0)
+        for _, f := range ft.Params.List {
+                for i := 0; i < len(f.Names) || i == 0 && len(f.Names) == 0; i++ {
+                        l = append(l, f.Type)
+                }
+        }
+        return l
+}
+
+// result is one value returned by a function.
+type result struct {
+        name string // empty for an unnamed result
+        typ  ast.Expr
+}
+
+// results lists the results of ft one per value, expanding grouped
+// names such as (q, r int).
+func results(ft *ast.FuncType) []result {
+        res := make([]result, 0)
+        if ft.Results == nil {
+                return res
+        }
+        for _, f := range ft.Results.List {
+                if len(f.Names) == 0 {
+                        res = append(res, result{typ: f.Type})
+                }
+                for _, name := range f.Names {
+                        res = append(res, result{name.Name, f.Type})
+                }
+        }
+        return res
+}
+
+// isEntry reports whether n is func main of package main translated
+// under -standalone.
+func isEntry(n *ast.FuncDecl) bool {
+        return *standalone && n != nil && pkgName == "main" && n.Recv == nil &&
+                n.Name.Name == "main"
+}
+
+// initName returns the C name of the init function n, numbered in
+// source order since a file may have several.
+func initName(n *ast.FuncDecl) string {
+        for i, d := range inits {
+                if d == n {
+                        return fmt.Sprintf("_init%d", i)
+                }
+        }
+        return n.Name.Name
+}
+
+// smallFunc is the largest number of statements a function body may
+// hold to be emitted static inline under -inline-small.
+const smallFunc = 3
+
+// countStmts returns the number of statements in n, nested ones included.
+func countStmts(n *ast.BlockStmt) int {
+        count := 0
+        ast.Inspect(n, func(n ast.Node) bool {
+                if _, ok := n.(ast.Stmt); ok {
+                        count++
+                }
+                return true
+        })
+        // The body itself is a statement.
+        return count - 1
+}
+
+// terminates reports whether every path through l ends in a call to
+// panic or os.Exit, so that control never reaches the caller.
+func terminates(l []ast.Stmt) bool {
+        if len(l) == 0 {
+                return false
+        }
+        switch t := l[len(l)-1].(type) {
+        case *ast.ExprStmt:
+                call, ok := t.X.(*ast.CallExpr)
+                if !ok {
+                        return false
+                }
+                switch fun := call.Fun.(type) {
+                case *ast.Ident:
+                        return fun.Name == "panic"
+                case *ast.SelectorExpr:
+                        pkg, ok := fun.X.(*ast.Ident)
+                        return ok && pkg.Name == "os" && fun.Sel.Name == "Exit"
+                }
+        case *ast.BlockStmt:
+                return terminates(t.List)
+        case *ast.IfStmt:
+                if t.Else == nil || !terminates(t.Body.List) {
+                        return false
+                }
+                return terminates([]ast.Stmt{t.Else})
+        }
+        return false
+}
+
+// hasReturn reports whether n holds a return statement outside of
+// function literals.
+func hasReturn(n ast.Node) bool {
+        found := false
+        ast.Inspect(n, func(n ast.Node) bool {
+                switch n.(type) {
+                case *ast.ReturnStmt:
+                        found = true
+                case *ast.FuncLit:
+                        return false
+                }
+                return !found
+        })
+        return found
+}
+
+func VisitFunction(p *Printer, n *ast.FuncDecl) {
+        curFunc = n
+        blockLabels = make(map[string]bool)
+        breaks, labelCount, spreadCount, rangeDepth = nil, 0, 0, 0
+        vars = make(map[string]ast.Expr)
+        scope = make(map[string]bool)
+        wrapped = make(map[string]bool)
+        // A method is a function taking its receiver first.
+        var recv *ast.Field
+        if n.Recv != nil && len(n.Recv.List) == 1 {
+                recv = n.Recv.List[0]
+                if len(recv.Names) == 0 || isBlank(recv.Names[0]) {
+                        recv = &ast.Field{Names: []*ast.Ident{ast.NewIdent("self")}, Type: recv.Type}
+                }
+                declare(recv.Names[0].Name, recv.Type)
+        }
+        for _, f := range n.Type.Params.List {
+                for _, name := range f.Names {
+                        declare(name.Name, f.Type)
+                        if isArray(f.Type) {
+                                wrapped[name.Name] = true
+                        }
+                }
+        }
+        defer func() { curFunc = nil }()
+
+        fun := n.Type
+        funcname := n.Name.Name
+        if recv != nil {
+                name, _ := receiver(n)
+                funcname = name + "_" + funcname
+        } else if funcname == "init" {
+                funcname = initName(n)
+        }
+        res := results(fun)
+        rettyp := "void"
+        if len(res) == 1 || errorResult(fun) {
+                rettyp = valueType(res[0].typ)
+        }
+
+        params := ""
+        paraml := make([]string, 0)
+        if recv != nil {
+                paraml = append(paraml, field(recv))
+        }
+        if fun.Params.NumFields() != 0 {
+                restrict := hasDirective(n.Doc, "restrict")
+                for i, f := range paramFields(fun) {
+                        // A C definition names every parameter.
+                        if len(f.Names) == 0 || isBlank(f.Names[0]) {
+                                f = &ast.Field{Names: []*ast.Ident{ast.NewIdent(fmt.Sprintf("_unused%d", i))}, Type: f.Type}
+                        }
+                        param := field(f)
+                        if at, ok := f.Type.(*ast.ArrayType); ok && at.Len != nil && len(f.Names) > 0 {
+                                param = fmt.Sprintf("%s %s", wrapperType(at), f.Names[0].Name)
+                        }
+                        if _, ok := f.Type.(*ast.StarExpr); ok && restrict && len(f.Names) > 0 {
+                                requireStd(c99)
+                                param = fmt.Sprintf("%s restrict %s", typ(f.Type), f.Names[0].Name)
+                        }
+                        paraml = append(paraml, param)
+                }
+        }
+        // C has a single return value, so multiple results are passed
+        // back through trailing out-parameters.
+        if errorResult(fun) {
+                paraml = append(paraml, fmt.Sprintf("%s* err", typ(res[1].typ)))
+        } else if len(res) > 1 {
+                for i, r := range res {
+                        paraml = append(paraml, fmt.Sprintf("%s* ret%d", valueType(r.typ), i))
+                }
+        }
+        params = strings.Join(paraml, ", ")
+
+        // A function without a body is implemented elsewhere, in
+        // assembly for instance, so only its prototype is emitted.
+        if n.Body == nil {
+                for _, w := range pendingDecls {
+                        p.Pln("%s", w)
+                }
+                pendingDecls = nil
+                p.Pln("%s %s(%s);", rettyp, funcname, params)
+                return
+        }
+        if isEntry(n) {
+                rettyp, funcname, params = "int", "main", "void"
+                // The entry point calls the init functions declared after it.
+                for _, d := range inits {
+                        if d.Pos() > n.Pos() {
+                                pendingDecls = append(pendingDecls, fmt.Sprintf("void %s();", initName(d)))
+                        }
+                }
+        } else {
+                if terminates(n.Body.List) && !hasReturn(n.Body) {
+                        requireStd(c11)
+                        rettyp = "_Noreturn " + rettyp
+                }
+                if *inlineSmall && countStmts(n.Body) <= smallFunc {
+                        rettyp = "static inline " + rettyp
+                }
+        }
+        body := &Printer{indent: p.indent}
+        before := len(unsupported)
+        failed := ""
+        func() {
+                defer recoverPartial(n, &failed)
+                VisitBody(body, n, res)
+        }()
+        // Under -partial a function that could not be fully translated
+        // becomes a stub, so the rest of the file still compiles.
+        if *partial && (failed != "" || len(unsupported) > before) {
+                if failed == "" {
+                        unsupport(n)
+                }
+                include("stdlib.h")
+                body = &Printer{indent: p.indent}
+                body.P("{\n")
+                body.Indent()
+                body.Pln("/* TODO: unsupported */")
+                body.Pln("abort();")
+                body.Unindent()
+                body.Pln("}")
+        }
+        for _, w := range pendingDecls {
+                p.Pln("%s", w)
+        }
+        pendingDecls = nil
+        p.Pln("%s %s(%s)", rettyp, funcname, params)
+        body.WriteTo(p)
+}
+
+// VisitBody emits the body of the function n, whose results are res.
+func VisitBody(p *Printer, n *ast.FuncDecl, res []result) {
+        p.P("{\n")
+        p.Indent()
+        // Named results are ordinary zeroed locals.
+        for _, r := range res {
+                if r.name != "" {
+                        VisitSpec(p, &ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(r.name)}, Type: r.typ})
+                }
+        }
+        if hasDefer(n.Body) && (len(res) == 1 || errorResult(n.Type)) && res[0].name == "" && !isEntry(n) {
+                p.Pln("%s _ret;", valueType(res[0].typ))
+        }
+        defers, cleanups = nil, make(map[int]bool)
+        asmDirectives = asmDirectives[:0]
+        for _, cg := range comments {
+                for _, c := range cg.List {
+                        if c.Pos() > n.Body.Lbrace && c.End() < n.Body.Rbrace && strings.HasPrefix(c.Text, "//goc:asm(") {
+                                asmDirectives = append(asmDirectives, c)
+                        }
+                }
+        }
+        if isEntry(n) {
+                for _, d := range inits {
+                        p.Pln("%s();", initName(d))
+                }
+        }
+        for _, elem := range n.Body.List {
+                topStmt = elem
+                VisitStmt(p, elem)
+        }
+        emitAsm(p, n.Body.Rbrace)
+        if len(defers) > 0 {
+                runDefers(p)
+        } else if isEntry(n) {
+                p.Pln("return 0;")
+        }
+        defers = nil
+        p.Unindent()
+        p.Pln("}")
+}
+
+// recoverPartial, deferred while translating n, turns a translation
+// error into an unsupported n under -partial, setting stub to its
+// placeholder.
+func recoverPartial(n ast.Node, stub *string) {
+        r := recover()
+        if r == nil {
+                return
+        }
+        e, ok := r.(*translateError)
+        if !ok || !*partial {
+                panic(r)
+        }
+        log.Printf("%s: warning: %s", fset.Position(e.pos), e.msg)
+        *stub = unsupport(n)
+}
+
+// isArray reports whether n is a fixed-size array type.
+func isArray(n ast.Expr) bool {
+        at, ok := n.(*ast.ArrayType)
+        return ok && at.Len != nil
+}
+
+// valueType returns the C type used to pass a value of type n. Arrays
+// decay to pointers in C, so they travel wrapped in a struct to keep
+// Go's copy semantics.
+func valueType(n ast.Expr) string {
+        if at, ok := n.(*ast.ArrayType); ok && at.Len != nil {
+                return wrapperType(at)
+        }
+        return typ(n)
+}
+
+// wrapperType returns the name of the struct wrapping array type at,
+// such as Arr4_int, declaring it before the current function if needed.
+func wrapperType(at *ast.ArrayType) string {
+        elt, dims := arrayDims(at)
+        et := typ(elt)
+        name := "Arr" + strings.NewReplacer("[", "", "]", "_").Replace(dims) +
+                strings.NewReplacer(" ", "_", "*", "p").Replace(et)
+        if !wrappers[name] {
+                wrappers[name] = true
+                pendingDecls = append(pendingDecls, fmt.Sprintf("typedef struct { %s a%s; } %s;", et, dims, name))
+        }
+        return name
+}
+
+// wrapValue converts n to the wrapper struct of array type at.
+func wrapValue(n ast.Expr, at *ast.ArrayType) string {
+        w := wrapperType(at)
+        if id, ok := n.(*ast.Ident); ok && wrapped[id.Name] {
+                return id.Name
+        }
+        if call, ok := n.(*ast.CallExpr); ok && isArray(exprType(call)) {
+                return expr(call)
+        }
+        if lit, ok := n.(*ast.CompositeLit); ok {
+                return fmt.Sprintf("(%s){%s}", w, braces(lit, nil))
+        }
+        // The wrapper has the layout of its only member.
+        return fmt.Sprintf("*(%s*)%s", w, expr(n))
+}
+
+// arrayDims splits a possibly nested array type into its element type
+// and the C dimensions that follow the declared name.
+func arrayDims(t *ast.ArrayType) (ast.Expr, string) {
+        dims := ""
+        var elt ast.Expr = t
+        for {
+                at, ok := elt.(*ast.ArrayType)
+                if !ok || at.Len == nil {
+                        break
+                }
+                if _, ok := at.Len.(*ast.Ellipsis); ok {
+                        dims += "[]"
+                } else {
+                        dims += "[" + expr(at.Len) + "]"
+                }
+                elt = at.Elt
+        }
+        return elt, dims
+}
+
+// padded returns t with its zero-length dimensions given one element, a
+// placeholder ISO C accepts. Go never lets it be indexed, and len still
+// reads the Go type.
+func padded(t *ast.ArrayType) *ast.ArrayType {
+        c := *t
+        if v, ok := constInt(t.Len); ok && v == 0 {
+                c.Len = &ast.BasicLit{Kind: token.INT, Value: "1"}
+        }
+        if at, ok := t.Elt.(*ast.ArrayType); ok && at.Len != nil {
+                c.Elt = padded(at)
+        }
+        return &c
+}
+
+// funcPtr declares name as a C pointer to a function of type t.
+func funcPtr(t *ast.FuncType, name string) string {
+        ret := "void"
+        if t.Results.NumFields() > 0 {
+                ret = typ(t.Results.List[0].Type)
+        }
+        params := make([]string, 0)
+        for _, f := range t.Params.List {
+                for i := 0; i < len(f.Names) || i == 0 && len(f.Names) == 0; i++ {
+                        params = append(params, typ(f.Type))
+                }
+        }
+        if len(params) == 0 {
+                params = append(params, "void")
+        }
+        return fmt.Sprintf("%s (*%s)(%s)", ret, name, strings.Join(params, ", "))
+}
+
+// zero returns the C initializer for the zero value of Go type n.
+func zero(n ast.Expr) string {
+        switch t := n.(type) {
+        case *ast.StarExpr, *ast.FuncType, *ast.MapType, *ast.ChanType, *ast.InterfaceType:
+                include("stddef.h")
+                return "NULL"
+        case *ast.Ident:
+                switch t.Name {
+                case "int", "int8", "int16", "int32", "int64",
+                        "uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+                        "byte", "rune", "float32", "float64", "bool":
+                        return "0"
+                case "string":
+                        return `""`
+                case "error":
+                        include("stddef.h")
+                        return "NULL"
+                }
+        }
+        return "{0}"
+}
+
+func VisitSpec(p *Printer, n ast.Spec) {
+        switch d := n.(type) {
+        case *ast.ValueSpec:
+                // Without a type each name takes that of its value.
+                if d.Type == nil && len(d.Values) == len(d.Names) {
+                        for i, name := range d.Names {
+                                t := defineType(name, d.Values[i])
+                                if curFunc != nil {
+                                        define(p, name, t, d.Values[i])
+                                        continue
+                                }
+                                if t == nil {
+                                        t = assumed(p, name)
+                                }
+                                VisitSpec(p, &ast.ValueSpec{Names: []*ast.Ident{name}, Type: t, Values: d.Values[i : i+1]})
+                        }
+                        return
+                }
+                if curFunc == nil {
+                        for _, name := range d.Names {
+                                globals[name.Name] = d.Type
+                        }
+                } else {
+                        for _, name := range d.Names {
+                                declare(name.Name, d.Type)
+                        }
+                }
+                if len(d.Values) > 0 && len(d.Values) != len(d.Names) {
+                        for _, name := range d.Names {
+                                dropped[name.Name] = true
+                        }
+                        p.Pln("%s", unsupport(d))
+                        return
+                }
+                for i, name := range d.Names {
+                        // Go zero-initializes every variable, C only globals.
+                        init := ""
+                        if len(d.Values) > 0 {
+                                init = " = " + initializer(d.Values[i], nil)
+                        } else if curFunc != nil {
+                                init = " = " + zero(d.Type)
+                        }
+                        switch t := d.Type.(type) {
+                        case *ast.ArrayType:
+                                if t.Len != nil && zeroLength(t) {
+                                        p.Pln("/* goc: %s has a zero-length array type, given one element */", name.Name)
+                                        t = padded(t)
+                                }
+                                // An array is only initialized from braces,
+                                // other values are copied in.
+                                var value ast.Expr
+                                if len(d.Values) > 0 && curFunc != nil && t.Len != nil {
+                                        if _, ok := d.Values[i].(*ast.CompositeLit); !ok {
+                                                value, init = d.Values[i], " = {0}"
+                                        }
+                                }
+                                elt, dims := arrayDims(t)
+                                p.Pln("%s %s%s%s;", typ(elt), name.Name, dims, init)
+                                if value != nil {
+                                        p.Pln("%s;", assignArray(name, value, t))
+                                }
+                        case *ast.StarExpr:
+                                p.Pln("%s%s;", field(&ast.Field{Names: []*ast.Ident{name}, Type: t}), init)
+                        case *ast.FuncType:
+                                p.Pln("%s%s;", funcPtr(t, name.Name), init)
+                        default:
+                                p.Pln("%s %s%s;", typ(d.Type), name.Name, init)
+                        }
+                }
+        case *ast.ImportSpec:
+                path, _ := strconv.Unquote(d.Path.Value)
+                if path == "errors" && *errorOut {
+                        return
+                }
+                // fmt calls become stdio calls.
+                if path == "fmt" {
+                        include("stdio.h")
+                        return
+                }
+                p.Pln(`#include <%s.h>`, path)
+        case *ast.TypeSpec:
+                // An alias is the same type under another name, which is
+                // exactly what a C typedef provides.
+                if d.Assign.IsValid() {
+                        p.Pln("typedef %s %s;", typ(d.Type), d.Name)
+                        return
+                }
+                switch t := d.Type.(type) {
+                case *ast.Ident:
+                        if gd := enums[d.Name.Name]; gd != nil {
+                                VisitConstDecl(p, gd)
+                                return
+                        }
+                        p.Pln("typedef %s %s;", ctype(t.Name), d.Name)
+                case *ast.StructType:
+                        kind := "struct"
+                        if hasDirective(d.Doc, "union") {
+                                kind = "union"
+                        }
+                        // The typedef lets the type go by its bare name,
+                        // as it does in Go, and point to itself.
+                        p.Pln("typedef %s %s %s;", kind, d.Name, d.Name)
+                        p.Pln("%s %s {", kind, d.Name)
+                        p.Indent()
+                        blank, run := 0, 0
+                        list := make([]*ast.Field, 0, len(t.Fields.List))
+                        for _, f := range t.Fields.List {
+                                // An embedded field is a member named
+                                // after its type.
+                                if len(f.Names) == 0 {
+                                        f = &ast.Field{Doc: f.Doc, Names: []*ast.Ident{ast.NewIdent(embeddedName(f.Type))}, Type: f.Type, Tag: f.Tag}
+                                }
+                                list = append(list, splitField(f)...)
+                        }
+                        for i, f := range list {
+                                // A //goc:flex buffer becomes a C99 flexible
+                                // array member after its length.
+                                if at, ok := f.Type.(*ast.ArrayType); ok && hasDirective(f.Doc, "flex") {
+                                        if i != len(list)-1 {
+                                                fail(f.Pos(), "goc:flex field must be the last field of its struct")
+                                        }
+                                        include("stddef.h")
+                                        requireStd(c99)
+                                        p.Pln("size_t %s_len;", f.Names[0].Name)
+                                        p.Pln("%s %s[];", typ(at.Elt), f.Names[0].Name)
+                                        continue
+                                }
+                                // Blank fields keep the layout but need a
+                                // unique C member name.
+                                if len(f.Names) > 0 && f.Names[0].Name == "_" {
+                                        if at, ok := f.Type.(*ast.ArrayType); ok && at.Len != nil {
+                                                p.Pln("%s _blank%d[%s];", typ(at.Elt), blank, expr(at.Len))
+                                        } else {
+                                                p.Pln("%s _blank%d;", typ(f.Type), blank)
+                                        }
+                                        blank++
+                                        continue
+                                }
+                                if bits := bitfield(f); bits > 0 {
+                                        // A field that does not fit after the
+                                        // previous ones starts a new unit.
+                                        if w := intWidth(f.Type); run%w+bits > w {
+                                                log.Printf("%s: warning: bitfield %s does not fit in the %d bits left in its unit",
+                                                        fset.Position(f.Pos()), field(f), w-run%w)
+                                                run += w - run%w
+                                        }
+                                        run += bits
+                                        p.Pln("%s : %d;", field(f), bits)
+                                        continue
+                                }
+                                run = 0
+                                p.Pln("%s;", field(f))
+                        }
+                        p.Unindent()
+                        if hasDirective(d.Doc, "packed") {
+                                p.Pln("} __attribute__((packed));")
+                        } else {
+                                p.Pln("};")
+                        }
+                default:
+                        p.Pln("%s", unsupport(d.Type))
+                }
+        }
+}
+
+// intWidth returns the width in bits of a predeclared integer type, or
+// 0 if n is not one.
+func intWidth(n ast.Expr) int {
+        id, ok := n.(*ast.Ident)
+        if !ok {
+                return 0
+        }
+        switch id.Name {
+        case "int8", "uint8", "byte":
+                return 8
+        case "int16", "uint16":
+                return 16
+        case "int32", "uint32", "rune":
+                return 32
+        case "int64", "uint64", "uintptr":
+                return 64
+        case "int", "uint":
+                if *intSize == 0 {
+                        return 32
+                }
+                return *intSize
+        }
+        return 0
+}
+
+// bitfield returns the width requested by a goc:"bits:N" tag on f, or
+// 0 if the field is an ordinary member.
+func bitfield(f *ast.Field) int {
+        if f.Tag == nil {
+                return 0
+        }
+        tag, err := strconv.Unquote(f.Tag.Value)
+        if err != nil {
+                return 0
+        }
+        v, ok := reflect.StructTag(tag).Lookup("goc")
+        if !ok || !strings.HasPrefix(v, "bits:") {
+                return 0
+        }
+        pos := f.Tag.Pos()
+        bits, err := strconv.Atoi(strings.TrimPrefix(v, "bits:"))
+        if err != nil || bits <= 0 {
+                fail(pos, "invalid bitfield width %q", v)
+        }
+        width := intWidth(f.Type)
+        if width == 0 {
+                fail(pos, "bitfield on non-integer type %s", expr(f.Type))
+        }
+        if bits > width {
+                fail(pos, "%d-bit field is wider than its %d-bit type", bits, width)
+        }
+        return bits
+}
+
+// resolve follows type names declared in the file to the type they
+// denote. Names declared elsewhere are assumed to be structs.
+func resolve(n ast.Expr) ast.Expr {
+        for i := 0; i < len(types); i++ {
+                id, ok := n.(*ast.Ident)
+                if !ok {
+                        return n
+                }
+                ts, ok := types[id.Name]
+                if !ok {
+                        break
+                }
+                n = ts.Type
+        }
+        if id, ok := n.(*ast.Ident); ok && ctypes[id.Name][0] == "" && id.Name != "int" && id.Name != "uint" {
+                return &ast.StructType{Fields: &ast.FieldList{}}
+        }
+        return n
+}
+
+// structName returns the C type of a struct type name, with the tag
+// keyword its declaration uses.
+func structName(n ast.Expr) string {
+        id, ok := n.(*ast.Ident)
+        if !ok {
+                return typ(n)
+        }
+        if ts, ok := types[id.Name]; ok && hasDirective(ts.Doc, "union") {
+                return "union " + id.Name
+        }
+        return "struct " + id.Name
+}
+
+// VisitConstDecl emits the specs of a const declaration in order. A
+// spec without values repeats the type and values of the last one that
+// had them, and iota counts the specs whether or not they do. A block
+// starting with iota becomes an enum of the integer values it folds to,
+// and the typedef of the type it enumerates if there is one.
+func VisitConstDecl(p *Printer, d *ast.GenDecl) {
+        first := d.Specs[0].(*ast.ValueSpec)
+        enum := len(first.Values) > 0 && reads(first.Values[0], map[string]bool{"iota": true})
+        members := make([]string, 0)
+        next := int64(0)
+        tag := enumType(d)
+        flush := func() {
+                if len(members) > 0 {
+                        if tag != "" && enums[tag] == d {
+                                p.Pln("typedef enum { %s } %s;", strings.Join(members, ", "), tag)
+                        } else {
+                                p.Pln("enum { %s };", strings.Join(members, ", "))
+                        }
+                        members = members[:0]
+                }
+        }
+
+        // The members of each enum type declared in the file, named
+        // by enumNames.
+        named := make(map[string][]string)
+        order := make([]string, 0)
+        var last *ast.ValueSpec
+        for i, spec := range d.Specs {
+                vs := spec.(*ast.ValueSpec)
+                if len(vs.Values) == 0 && last != nil {
+                        vs = &ast.ValueSpec{Names: vs.Names, Type: last.Type, Values: last.Values}
+                } else {
+                        last = vs
+                }
+                iotaValue = i
+                checkConst(vs)
+                if enum && len(vs.Values) == len(vs.Names) && isIntType(vs.Type) {
+                        vals := make([]int64, len(vs.Names))
+                        ok := true
+                        for j, v := range vs.Values {
+                                vals[j], ok = constInt(v)
+                                // Enum constants must fit in a C int.
+                                if !ok || vals[j] != int64(int32(vals[j])) {
+                                        ok = false
+                                        break
+                                }
+                        }
+                        if ok {
+                                for j, name := range vs.Names {
+                                        if isBlank(name) {
+                                                continue
+                                        }
+                                        consts[name.Name] = vals[j]
+                                        if id, ok := vs.Type.(*ast.Ident); ok && types[id.Name] != nil {
+                                                if named[id.Name] == nil {
+                                                        order = append(order, id.Name)
+                                                }
+                                                named[id.Name] = append(named[id.Name], name.Name)
+                                                enumConsts[id.Name] = append(enumConsts[id.Name], name.Name)
+                                        }
+                                        m := name.Name
+                                        if vals[j] != next {
+                                                m = fmt.Sprintf("%s = %d", name.Name, vals[j])
+                                        }
+                                        members = append(members, m)
+                                        next = vals[j] + 1
+                                }
+                                continue
+                        }
+                }
+                flush()
+                VisitConstSpec(p, vs)
+        }
+        flush()
+        iotaValue = -1
+        for _, t := range order {
+                if *enumStrings {
+                        enumNames(p, t, named[t])
+                }
+        }
+}
+
+// enumType returns the type of the file whose values the const block d
+// enumerates, or "" if it is no such block. Such a block starts with
+// iota, declares only constants of the one type, an int underneath,
+// and folds them, so that the type can be declared as the enum where
+// the type is.
+func enumType(d *ast.GenDecl) string {
+        first, ok := d.Specs[0].(*ast.ValueSpec)
+        if d.Tok != token.CONST || !ok || len(first.Values) == 0 || !reads(first.Values[0], map[string]bool{"iota": true}) {
+                return ""
+        }
+        id, ok := first.Type.(*ast.Ident)
+        if !ok || types[id.Name] == nil || types[id.Name].Assign.IsValid() || *intSize != 0 {
+                return ""
+        }
+        if u, ok := types[id.Name].Type.(*ast.Ident); !ok || u.Name != "int" {
+                return ""
+        }
+        defer func(v int) { iotaValue = v }(iotaValue)
+        last := first
+        for i, spec := range d.Specs {
+                vs := spec.(*ast.ValueSpec)
+                if len(vs.Values) == 0 {
+                        vs = &ast.ValueSpec{Names: vs.Names, Type: last.Type, Values: last.Values}
+                } else {
+                        last = vs
+                }
+                if t, ok := vs.Type.(*ast.Ident); !ok || t.Name != id.Name || len(vs.Values) != len(vs.Names) {
+                        return ""
+                }
+                iotaValue = i
+                for _, v := range vs.Values {
+                        if c, ok := constInt(v); !ok || c != int64(int32(c)) {
+                                return ""
+                        }
+                }
+        }
+        return id.Name
+}
+
+// enumNames emits a table of the names of the members of enum type t,
+// indexed by value, and a T_String function looking them up, both
+// static so that every file including them gets its own.
+func enumNames(p *Printer, t string, members []string) {
+        max := int64(0)
+        for _, m := range members {
+                // Negative values cannot index the table.
+                if consts[m] < 0 {
+                        return
+                }
+                if consts[m] > max {
+                        max = consts[m]
+                }
+        }
+        include("stddef.h")
+        requireStd(c99)
+        p.Pln("static const char* %s_names[%d] = {", t, max+1)
+        p.Indent()
+        for _, m := range members {
+                p.Pln(`[%s] = "%s",`, m, m)
+        }
+        p.Unindent()
+        p.Pln("};")
+        p.Pln("static inline const char* %s_String(%s v)", t, t)
+        p.Pln("{")
+        p.Indent()
+        p.Pln("if ((size_t)v >= %d || %s_names[v] == NULL) {", max+1, t)
+        p.Indent()
+        p.Pln(`return "%s(?)";`, t)
+        p.Unindent()
+        p.Pln("}")
+        p.Pln("return %s_names[v];", t)
+        p.Unindent()
+        p.Pln("}")
+}
+
+// checkConst stops with an error if a value of n overflows its integer
+// type, as Go rejects such constants.
+func checkConst(n *ast.ValueSpec) {
+        if n.Type == nil || len(n.Values) != len(n.Names) {
+                return
+        }
+        id, ok := resolve(n.Type).(*ast.Ident)
+        if !ok || intWidth(id) == 0 {
+                return
+        }
+        w := uint(intWidth(id))
+        unsigned := strings.HasPrefix(id.Name, "u") || id.Name == "byte"
+        for i, v := range n.Values {
+                c, ok := constInt(v)
+                if !ok {
+                        continue
+                }
+                var fits bool
+                switch {
+                case unsigned:
+                        fits = c >= 0 && (w == 64 || c < 1<<w)
+                case w == 64:
+                        fits = true
+                default:
+                        fits = c >= -(1<<(w-1)) && c < 1<<(w-1)
+                }
+                if !fits {
+                        fail(n.Values[i].Pos(), "constant %d overflows %s", c, expr(n.Type))
+                }
+        }
+}
+
+// isIntType reports whether a constant of type n, nil if untyped, can
+// be an enum constant.
+func isIntType(n ast.Expr) bool {
+        if n == nil {
+                return true
+        }
+        if id, ok := resolve(n).(*ast.Ident); ok {
+                return intWidth(id) > 0
+        }
+        return false
+}
+
+// constInt folds an integer constant expression built from literals,
+// iota and constants already folded.
+func constInt(n ast.Expr) (int64, bool) {
+        switch t := n.(type) {
+        case *ast.BasicLit:
+                switch t.Kind {
+                case token.INT:
+                        v, err := strconv.ParseInt(t.Value, 0, 64)
+                        return v, err == nil
+                case token.CHAR:
+                        return int64(runeValue(t)), true
+                }
+        case *ast.Ident:
+                if t.Name == "iota" && iotaValue >= 0 {
+                        return int64(iotaValue), true
+                }
+                v, ok := consts[t.Name]
+                return v, ok
+        case *ast.ParenExpr:
+                return constInt(t.X)
+        case *ast.UnaryExpr:
+                x, ok := constInt(t.X)
+                switch t.Op {
+                case token.ADD:
+                        return x, ok
+                case token.SUB:
+                        return -x, ok
+                case token.XOR:
+                        return ^x, ok
+                }
+        case *ast.CallExpr:
+                // A conversion such as Color(iota).
+                if id, ok := t.Fun.(*ast.Ident); ok && len(t.Args) == 1 && isIntType(id) {
+                        return constInt(t.Args[0])
+                }
+                // The length of a string literal.
+                if id, ok := t.Fun.(*ast.Ident); ok && id.Name == "len" && len(t.Args) == 1 {
+                        if lit, ok := t.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+                                s, err := strconv.Unquote(lit.Value)
+                                return int64(len(s)), err == nil
+                        }
+                }
+        case *ast.BinaryExpr:
+                x, ok := constInt(t.X)
+                if !ok {
+                        return 0, false
+                }
+                y, ok := constInt(t.Y)
+                if !ok {
+                        return 0, false
+                }
+                switch t.Op {
+                case token.ADD:
+                        return x + y, true
+                case token.SUB:
+                        return x - y, true
+                case token.MUL:
+                        return x * y, true
+                case token.QUO:
+                        return x / y, y != 0
+                case token.REM:
+                        return x % y, y != 0
+                case token.SHL:
+                        return x << uint64(y), y >= 0
+                case token.SHR:
+                        return x >> uint64(y), y >= 0
+                case token.AND:
+                        return x & y, true
+                case token.OR:
+                        return x | y, true
+                case token.XOR:
+                        return x ^ y, true
+                case token.AND_NOT:
+                        return x &^ y, true
+                }
+        }
+        return 0, false
+}
+
+// VisitConstSpec emits a constant declaration. Typed integer constants
+// become enum constants, other typed constants static const variables
+// of their C type; untyped ones become macros, or static const under
+// -static-const.
+func VisitConstSpec(p *Printer, n *ast.ValueSpec) {
+        if len(n.Values) != len(n.Names) {
+                p.Pln("%s", unsupport(n))
+                return
+        }
+        for i, name := range n.Names {
+                if isBlank(name) {
+                        continue
+                }
+                t := n.Type
+                if t == nil && *staticConst {
+                        if t = exprType(n.Values[i]); t == nil {
+                                t = ast.NewIdent(*defaultType)
+                        }
+                }
+                if t == nil {
+                        var v string
+                        if c, ok := constInt(n.Values[i]); ok {
+                                consts[name.Name] = c
+                                if v = strconv.FormatInt(c, 10); c != int64(int32(c)) {
+                                        v += "LL"
+                                }
+                                if c < 0 {
+                                        v = "(" + v + ")"
+                                }
+                                if call, ok := n.Values[i].(*ast.CallExpr); ok {
+                                        if ch, ok := charConst(call); ok {
+                                                v = ch
+                                        }
+                                }
+                        } else {
+                                v = expr(n.Values[i])
+                                switch n.Values[i].(type) {
+                                case *ast.BasicLit, *ast.Ident, *ast.BinaryExpr, *ast.ParenExpr:
+                                default:
+                                        v = "(" + v + ")"
+                                }
+                        }
+                        // Directives stay in the first column.
+                        p.P("#define %s %s\n", name.Name, v)
+                        continue
+                }
+                // A static const is no constant expression in C, so typed
+                // integers become enum constants to serve as array
+                // lengths and case labels.
+                if c, ok := constInt(n.Values[i]); ok && n.Type != nil && isIntType(n.Type) {
+                        consts[name.Name] = c
+                        globals[name.Name] = n.Type
+                        // A printable byte reads best as a character.
+                        if id, ok := n.Type.(*ast.Ident); ok && id.Name == "byte" && c >= 0x20 && c <= 0x7e {
+                                p.Pln("enum { %s = %s };", name.Name, cchar(rune(c)))
+                        } else if c == int64(int32(c)) {
+                                p.Pln("enum { %s = %d };", name.Name, c)
+                        } else {
+                                p.P("#define %s ((%s)%dLL)\n", name.Name, typ(n.Type), c)
+                        }
+                        continue
+                }
+                p.Pln("static const %s %s = %s;", typ(t), name.Name, expr(n.Values[i]))
+        }
+}
+
+// emitAsm emits the //goc:asm("...") directives of curFunc placed
+// before pos as basic asm statements, the string passed through as is.
+func emitAsm(p *Printer, pos token.Pos) {
+        for len(asmDirectives) > 0 && asmDirectives[0].Pos() < pos {
+                c := asmDirectives[0]
+                asmDirectives = asmDirectives[1:]
+                arg := strings.TrimSuffix(strings.TrimPrefix(c.Text, "//goc:asm("), ")")
+                code, err := strconv.Unquote(strings.TrimSpace(arg))
+                if err != nil {
+                        fail(c.Pos(), "invalid goc:asm directive: %s", c.Text)
+                }
+                p.Pln("__asm__(%s);", cstring(code))
+        }
+}
+
+// hasDirective reports whether the comment group holds a //goc:name
+// directive line.
+func hasDirective(doc *ast.CommentGroup, name string) bool {
+        if doc == nil {
+                return false
+        }
+        for _, c := range doc.List {
+                if strings.TrimSpace(c.Text) == "//goc:"+name {
+                        return true
+                }
+        }
+        return false
+}
+
+func VisitDecl(p *Printer, n ast.Decl) {
+        switch d := n.(type) {
+        case *ast.FuncDecl:
+                VisitFunction(p, d)
+        case *ast.GenDecl:
+                if d.Tok == token.CONST {
+                        if t := enumType(d); t == "" || enums[t] != d {
+                                VisitConstDecl(p, d)
+                        }
+                        return
+                }
+                for _, spec := range d.Specs {
+                        VisitSpec(p, spec)
+                }
+        default:
+                fail(d.Pos(), "unsupport declear type %T", d)
+        }
+}
+
+func VisitFile(p *Printer, n *ast.File) {
+        pkgName = n.Name.Name
+        comments = n.Comments
+        for _, decl := range n.Decls {
+                if d, ok := decl.(*ast.FuncDecl); ok && d.Recv == nil {
+                        funcs[d.Name.Name] = d
+                        if d.Name.Name == "init" {
+                                inits = append(inits, d)
+                        }
+                }
+                if d, ok := decl.(*ast.FuncDecl); ok && d.Recv != nil && len(d.Recv.List) == 1 {
+                        recv, _ := receiver(d)
+                        methods[recv+"."+d.Name.Name] = d
+                }
+                if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.TYPE {
+                        for _, spec := range d.Specs {
+                                ts := spec.(*ast.TypeSpec)
+                                // The doc comment of an ungrouped declaration
+                                // belongs to the GenDecl, not to its spec.
+                                if ts.Doc == nil && !d.Lparen.IsValid() {
+                                        ts.Doc = d.Doc
+                                }
+                                types[ts.Name.Name] = ts
+                        }
+                }
+        }
+        // No constant is folded yet, so the blocks enumerating a type
+        // must fold on their own.
+        for _, decl := range n.Decls {
+                if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.CONST {
+                        if t := enumType(d); t != "" && enums[t] == nil {
+                                enums[t] = d
+                        }
+                }
+        }
+        for _, decl := range n.Decls {
+                // Under -partial a declaration that cannot be translated
+                // is left out rather than ending the translation.
+                d := &Printer{indent: p.indent}
+                stub := ""
+                func() {
+                        defer recoverPartial(decl, &stub)
+                        VisitDecl(d, decl)
+                }()
+                if stub != "" {
+                        p.Pln("%s", stub)
+                        continue
+                }
+                d.WriteTo(p)
+        }
+}
+
+// feature is a Go language feature tallied by -features.
+type feature struct {
+        name      string
+        supported bool
+        match     func(ast.Node) bool
+}
+
+var featureList = []feature{
+        {"structs", true, func(n ast.Node) bool {
+                _, ok := n.(*ast.StructType)
+                return ok
+        }},
+        {"pointers", true, func(n ast.Node) bool {
+                _, ok := n.(*ast.StarExpr)
+                return ok
+        }},
+        {"for loops", true, func(n ast.Node) bool {
+                _, ok := n.(*ast.ForStmt)
+                return ok
+        }},
+        {"methods", true, func(n ast.Node) bool {
+                f, ok := n.(*ast.FuncDecl)
+                return ok && f.Recv != nil
+        }},
+        {"multiple returns", true, func(n ast.Node) bool {
+                f, ok := n.(*ast.FuncType)
+                return ok && f.Results.NumFields() > 1
+        }},
+        {"slices", false, func(n ast.Node) bool {
+                a, ok := n.(*ast.ArrayType)
+                return ok && a.Len == nil
+        }},
+        {"maps", false, func(n ast.Node) bool {
+                _, ok := n.(*ast.MapType)
+                return ok
+        }},
+        {"range loops", true, func(n ast.Node) bool {
+                _, ok := n.(*ast.RangeStmt)
+                return ok
+        }},
+        {"switches", true, func(n ast.Node) bool {
+                _, ok := n.(*ast.SwitchStmt)
+                return ok
+        }},
+        {"type switches", false, func(n ast.Node) bool {
+                _, ok := n.(*ast.TypeSwitchStmt)
+                return ok
+        }},
+        {"composite literals", true, func(n ast.Node) bool {
+                _, ok := n.(*ast.CompositeLit)
+                return ok
+        }},
+        {"closures", false, func(n ast.Node) bool {
+                _, ok := n.(*ast.FuncLit)
+                return ok
+        }},
+        {"defers", true, func(n ast.Node) bool {
+                _, ok := n.(*ast.DeferStmt)
+                return ok
+        }},
+        {"interfaces", false, func(n ast.Node) bool {
+                switch n.(type) {
+                case *ast.InterfaceType, *ast.TypeAssertExpr:
+                        return true
+                }
+                return false
+        }},
+        {"goroutines", false, func(n ast.Node) bool {
+                _, ok := n.(*ast.GoStmt)
+                return ok
+        }},
+        {"channels", false, func(n ast.Node) bool {
+                switch t := n.(type) {
+                case *ast.ChanType, *ast.SendStmt, *ast.SelectStmt:
+                        return true
+                case *ast.UnaryExpr:
+                        return t.Op == token.ARROW
+                }
+                return false
+        }},
+        {"generics", false, func(n ast.Node) bool {
+                switch t := n.(type) {
+                case *ast.FuncType:
+                        return t.TypeParams != nil
+                case *ast.TypeSpec:
+                        return t.TypeParams != nil
+                }
+                return false
+        }},
+}
+
+// printFeatures writes how often each feature occurs in f, in the
+// order of featureList, and whether goc can translate it.
+func printFeatures(w io.Writer, f *ast.File) {
+        counts := make([]int, len(featureList))
+        ast.Inspect(f, func(n ast.Node) bool {
+                for i, ft := range featureList {
+                        if n != nil && ft.match(n) {
+                                counts[i]++
+                        }
+                }
+                return true
+        })
+        for i, ft := range featureList {
+                if counts[i] == 0 {
+                        continue
+                }
+                status := "unsupported"
+                if ft.supported {
+                        status = "supported"
+                }
+                fmt.Fprintf(w, "%-20s %4d  %s\n", ft.name, counts[i], status)
+        }
+}
+
+// reset clears the state left by a previous translation.
+func reset() {
+        pkgName, curFunc, comments = "", nil, nil
+        blockLabels = make(map[string]bool)
+        breaks, asmDirectives, defers, topStmt = nil, nil, nil, nil
+        cleanups = make(map[int]bool)
+        labelCount, spreadCount, rangeDepth, iotaValue = 0, 0, 0, -1
+        consts = make(map[string]int64)
+        wrapped = make(map[string]bool)
+        wrappers, pendingDecls = make(map[string]bool), nil
+        vars, scope = make(map[string]ast.Expr), make(map[string]bool)
+        globals, dropped = make(map[string]ast.Expr), make(map[string]bool)
+        types = make(map[string]*ast.TypeSpec)
+        funcs = make(map[string]*ast.FuncDecl)
+        methods = make(map[string]*ast.FuncDecl)
+        inits, enums = nil, make(map[string]*ast.GenDecl)
+        enumConsts = make(map[string][]string)
+        unsupported, cstd = nil, 0
+        includes = make(map[string]bool)
+}
+
+// translate returns the C translation of the Go file src.
+func translate(filename string, src []byte) (out string, err error) {
+        reset()
+        source = src
+        fset = token.NewFileSet()
+        f, err := parser.ParseFile(fset, filename, source, parser.ParseComments)
+        if err != nil {
+                return "", err
+        }
+        defer func() {
+                if r := recover(); r != nil {
+                        e, ok := r.(*translateError)
+                        if !ok {
+                                panic(r)
+                        }
+                        err = e
+                }
+        }()
+        p := NewPrinter()
+        VisitFile(p, f)
+        if *maxLine > 0 {
+                p.Wrap(*maxLine)
+        }
+
+        hdr := NewPrinter()
+        if cstd > 0 {
+                hdr.Pln("#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < %dL", cstd)
+                hdr.Pln(`#error "this file requires %s or later"`, stdNames[cstd])
+                hdr.Pln("#endif")
+        }
+        headers := make([]string, 0, len(includes))
+        for h := range includes {
+                headers = append(headers, h)
+        }
+        sort.Strings(headers)
+        for _, h := range headers {
+                hdr.Pln("#include <%s>", h)
+        }
+        return hdr.String() + p.String(), nil
+}
+
+func main() {
+        flag.Parse()
+        if flag.NArg() < 1 {
+                log.Fatal("missing source file")
+        }
+        src := flag.Args()[0]
+        data, err := os.ReadFile(src)
+        if err != nil {
+                log.Fatal(err)
+        }
+        if *printAST || *features {
+                fset = token.NewFileSet()
+                f, err := parser.ParseFile(fset, src, data, parser.ParseComments)
+                if err != nil {
+                        log.Fatal(err)
+                }
+                if *printAST {
+                        ast.Print(fset, f)
+                }
+                if *features {
+                        printFeatures(os.Stdout, f)
+                        return
+                }
+        }
+        if *intSize != 0 && *intSize != 32 && *intSize != 64 {
+                log.Fatalf("invalid -int-size %d: must be 32 or 64", *intSize)
+        }
+        out, err := translate(src, data)
+        if err != nil {
+                log.Fatal(err)
+        }
+        if *report != "" {
+                if unsupported == nil {
+                        unsupported = []Unsupported{}
+                }
+                data, err := json.MarshalIndent(unsupported, "", "  ")
+                if err != nil {
+                        log.Fatal(err)
+                }
+                if err := os.WriteFile(*report, append(data, '\n'), 0644); err != nil {
+                        log.Fatal(err)
+                }
+        }
+        os.Stdout.WriteString(out)
 }
@@ -0,0 +1,2898 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "log"
+        "os"
+        "os/exec"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+// TestMain runs the test binary as goc itself when GOC_TEST_MAIN is
+// set, so that the tests can drive the translator as a command.
+func TestMain(m *testing.M) {
+        if os.Getenv("GOC_TEST_MAIN") == "1" {
+                log.SetFlags(0)
+                main()
+                os.Exit(0)
+        }
+        os.Exit(m.Run())
+}
+
+// goc translates src as test.go in dir with the given flags and
+// returns what goc printed on stdout and stderr.
+func goc(t *testing.T, dir string, flags map[string]string, src string) (string, string, error) {
+        t.Helper()
+        if err := os.WriteFile(filepath.Join(dir, "test.go"), []byte("package main\n\n"+src), 0644); err != nil {
+                t.Fatal(err)
+        }
+        var args []string
+        for name, v := range flags {
+                args = append(args, "-"+name+"="+v)
+        }
+        cmd := exec.Command(os.Args[0], append(args, "test.go")...)
+        cmd.Dir = dir
+        cmd.Env = append(os.Environ(), "GOC_TEST_MAIN=1")
+        var stdout, stderr bytes.Buffer
+        cmd.Stdout, cmd.Stderr = &stdout, &stderr
+        err := cmd.Run()
+        return stdout.String(), stderr.String(), err
+}
+
+var translateTests = []struct {
+        name  string
+        flags map[string]string
+        src   string
+        want  string
+}{
+        {
+                name:  "assert guards dereferences",
+                flags: map[string]string{"assert": "true"},
+                src: `func get(p *int) int {
+        return *p
+}
+`,
+                want: `#include <assert.h>
+#include <stddef.h>
+int get(int* p)
+{
+    return *(assert(p != NULL), p);
+}
+`,
+        },
+        {
+                name:  "standalone main",
+                flags: map[string]string{"standalone": "true"},
+                src: `var ready int
+
+func start() {
+        ready = 1
+}
+
+func main() {
+        start()
+}
+`,
+                want: `int ready;
+void start()
+{
+    ready = 1;
+}
+int main(void)
+{
+    start();
+    return 0;
+}
+`,
+        },
+        {
+                name:  "max-line wraps long lines",
+                flags: map[string]string{"max-line": "40"},
+                src: `func f(alpha, beta, gamma, delta int) int {
+        return alpha*beta + beta*gamma + gamma*delta + delta*alpha
+}
+`,
+                want: `int f(int alpha, int beta, int gamma,
+        int delta)
+{
+    return ((((alpha*beta)+(beta*
+            gamma))+(gamma*delta))+
+            (delta*alpha));
+}
+`,
+        },
+        {
+                name:  "inline-small",
+                flags: map[string]string{"inline-small": "true"},
+                src: `func sq(x int) int {
+        return x * x
+}
+`,
+                want: `static inline int sq(int x)
+{
+    return (x*x);
+}
+`,
+        },
+        {
+                name: "packed struct",
+                src: `//goc:packed
+type Header struct {
+        Tag  uint8
+        Size uint32
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+typedef struct Header Header;
+struct Header {
+    uint8_t Tag;
+    uint32_t Size;
+} __attribute__((packed));
+`,
+        },
+        {
+                name: "byte of printable constant",
+                src: `func f() byte {
+        return byte(65)
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+uint8_t f()
+{
+    return 'A';
+}
+`,
+        },
+        {
+                name: "byte constant of a character",
+                src: `const Letter byte = 'A'
+
+const Next = byte('B')
+
+const Newline byte = '\n'
+`,
+                want: `enum { Letter = 'A' };
+#define Next 'B'
+enum { Newline = 10 };
+`,
+        },
+        {
+                name: "multi-variable for init and post",
+                src: `func f() int {
+        n := 0
+        for i, j := 0, 10; i < j; i, j = i+1, j-1 {
+                n += j - i
+        }
+        return n
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int f()
+{
+    int n = 0;
+    for (int i = 0, j = 10; (i<j); i = (i+1), j = (j-1)) {
+        n += (j-i);
+    }
+    return n;
+}
+`,
+        },
+        {
+                name: "for post reading an earlier target",
+                src: `func fib(n int) int {
+        a, b := 0, 1
+        for i := 0; i < n; a, b = b, a+b {
+                i++
+        }
+        return a
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int fib(int n)
+{
+    int a = 0;
+    int b = 1;
+    for (int i = 0; (i<n); /* unsupported: *ast.AssignStmt */) {
+        i++;
+    }
+    return a;
+}
+`,
+        },
+        {
+                name: "zeroed locals",
+                src: `type P struct {
+        X int
+}
+
+func f() int {
+        var x int
+        var p P
+        var a [4]int
+        return x + p.X + a[0]
+}
+`,
+                want: `typedef struct P P;
+struct P {
+    int X;
+};
+int f()
+{
+    int x = 0;
+    P p = {0};
+    int a[4] = {0};
+    return ((x+p.X)+a[0]);
+}
+`,
+        },
+        {
+                name: "restrict parameters",
+                src: `//goc:restrict
+func cp(dst *int, src *int) {
+        *dst = *src
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+void cp(int* restrict dst, int* restrict src)
+{
+    *dst = *src;
+}
+`,
+        },
+        {
+                name: "complex literal",
+                src: `func f() complex128 {
+        return 1 + 2i
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <complex.h>
+double _Complex f()
+{
+    return (1+(2*I));
+}
+`,
+        },
+        {
+                name: "blank struct fields",
+                src: `type T struct {
+        A int32
+        _ int32
+        _ int32
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+typedef struct T T;
+struct T {
+    int32_t A;
+    int32_t _blank0;
+    int32_t _blank1;
+};
+`,
+        },
+        {
+                name:  "partial stubs",
+                flags: map[string]string{"partial": "true"},
+                src: `func ok() int {
+        return 1
+}
+
+func bad() {
+        go ok()
+}
+`,
+                want: `#include <stdlib.h>
+int ok()
+{
+    return 1;
+}
+void bad()
+{
+    /* TODO: unsupported */
+    abort();
+}
+`,
+        },
+        {
+                name:  "partial stubs for translation errors",
+                flags: map[string]string{"partial": "true"},
+                src: `type T struct {
+        A uint8 ` + "`" + `goc:"bits:9"` + "`" + `
+}
+
+func bad() bool {
+        return bool(1)
+}
+
+func asm() {
+        //goc:asm(nop)
+}
+
+func ok() int {
+        return 1
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdbool.h>
+#include <stdlib.h>
+/* unsupported: *ast.GenDecl */
+bool bad()
+{
+    /* TODO: unsupported */
+    abort();
+}
+void asm()
+{
+    /* TODO: unsupported */
+    abort();
+}
+int ok()
+{
+    return 1;
+}
+`,
+        },
+        {
+                name: "noreturn",
+                src: `func die() {
+        panic("die")
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 201112L
+#error "this file requires C11 or later"
+#endif
+_Noreturn void die()
+{
+    panic("die");
+}
+`,
+        },
+        {
+                name: "noreturn needs every return unreachable",
+                src: `func f(x int) int {
+        if x > 0 {
+                return 1
+        }
+        panic("no")
+}
+
+func g(x int) int {
+        switch x {
+        case 1:
+                return 1
+        }
+        panic("no")
+}
+`,
+                want: `int f(int x)
+{
+    if ((x>0)) {
+        return 1;
+    }
+    panic("no");
+}
+int g(int x)
+{
+    switch (x) {
+    case 1:
+        return 1;
+    }
+    panic("no");
+}
+`,
+        },
+        {
+                name: "functions without a body",
+                src: `func add(a, b int) int
+
+func f() int {
+        return add(1, 2)
+}
+`,
+                want: `int add(int a, int b);
+int f()
+{
+    return add(1, 2);
+}
+`,
+        },
+        {
+                name:  "int-size 64",
+                flags: map[string]string{"int-size": "64"},
+                src: `func f(n int, u uint) int {
+        return n + int(u)
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+int64_t f(int64_t n, uint64_t u)
+{
+    return (n+(int64_t)u);
+}
+`,
+        },
+        {
+                name: "type alias",
+                src: `type Num = int
+
+func f(n Num) Num {
+        return n
+}
+`,
+                want: `typedef int Num;
+Num f(Num n)
+{
+    return n;
+}
+`,
+        },
+        {
+                name: "union",
+                src: `//goc:union
+type Value struct {
+        I int32
+        F float32
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+typedef union Value Value;
+union Value {
+    int32_t I;
+    float F;
+};
+`,
+        },
+        {
+                name: "bitfields",
+                src: `type Flags struct {
+        A uint8 ` + "`" + `goc:"bits:3"` + "`" + `
+        B uint8 ` + "`" + `goc:"bits:5"` + "`" + `
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+typedef struct Flags Flags;
+struct Flags {
+    uint8_t A : 3;
+    uint8_t B : 5;
+};
+`,
+        },
+        {
+                name: "flexible array member",
+                src: `type Buf struct {
+        N int
+        //goc:flex
+        Data [0]byte
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stddef.h>
+#include <stdint.h>
+typedef struct Buf Buf;
+struct Buf {
+    int N;
+    size_t Data_len;
+    uint8_t Data[];
+};
+`,
+        },
+        {
+                name: "parenthesized expressions",
+                src: `func f(a, b, c int) int {
+        return (a + b) * c
+}
+`,
+                want: `int f(int a, int b, int c)
+{
+    return ((a+b)*c);
+}
+`,
+        },
+        {
+                name: "string literals",
+                src: `func f() string {
+        return "tab\t\"quoted\" \u00e9"
+}
+`,
+                want: `char* f()
+{
+    return "tab\t\"quoted\" \xc3\xa9";
+}
+`,
+        },
+        {
+                name: "rune literals",
+                src: `func f() rune {
+        return 'x' + '\n'
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+int32_t f()
+{
+    return ('x'+'\n');
+}
+`,
+        },
+        {
+                name: "array initializers",
+                src: `func f() int {
+        a := [3]int{1, 2, 3}
+        var b [4]int = [4]int{2: 5}
+        return a[0] + b[2]
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int f()
+{
+    int a[3] = {1, 2, 3};
+    int b[4] = {[2] = 5};
+    return (a[0]+b[2]);
+}
+`,
+        },
+        {
+                name: "array values outside initializers",
+                src: `func first(a [3]int) int {
+        return a[0]
+}
+
+func f() int {
+        var a [3]int
+        a = [3]int{4, 5, 6}
+        b := a
+        var e [3]int = [3]int{}
+        var z [0]int
+        _ = z
+        return first([3]int{1, 2, 3}) + [2]int{7, 8}[1] + b[0] + e[0]
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <string.h>
+typedef struct { int a[3]; } Arr3_int;
+int first(Arr3_int a)
+{
+    return a.a[0];
+}
+int f()
+{
+    int a[3] = {0};
+    memcpy(a, (int[3]){4, 5, 6}, sizeof a);
+    int b[3] = {0};
+    memcpy(b, a, sizeof b);
+    int e[3] = {0};
+    /* goc: z has a zero-length array type, given one element */
+    int z[1] = {0};
+    (void)z;
+    return (((first((Arr3_int){{1, 2, 3}})+(int[2]){7, 8}[1])+b[0])+e[0]);
+}
+`,
+        },
+        {
+                name: "zero-length array literals",
+                src: `func f() int {
+        z := [0]int{}
+        var w [2][0]int
+        return len(z) + len(w) + len([0]int{})
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int f()
+{
+    /* goc: z has a zero-length array type, given one element */
+    int z[1] = {0};
+    /* goc: w has a zero-length array type, given one element */
+    int w[2][1] = {0};
+    return (((int)(0)+(int)(sizeof(w)/sizeof(w[0])))+(int)(0));
+}
+`,
+        },
+        {
+                name: "blank assignment of a dropped declaration",
+                src: `func two() (int, int) {
+        return 1, 2
+}
+
+func f() {
+        var a, b = two()
+        _ = a
+        _ = b
+}
+`,
+                want: `void two(int* ret0, int* ret1)
+{
+    *ret0 = 1;
+    *ret1 = 2;
+    return;
+}
+void f()
+{
+    /* unsupported: *ast.ValueSpec */
+}
+`,
+        },
+        {
+                name: "keyed struct literals",
+                src: `type P struct {
+        X, Y int
+}
+
+func f() int {
+        p := P{Y: 2, X: 1}
+        return p.X + p.Y
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+typedef struct P P;
+struct P {
+    int X;
+    int Y;
+};
+int f()
+{
+    P p = {.Y = 2, .X = 1};
+    return (p.X+p.Y);
+}
+`,
+        },
+        {
+                name: "nil",
+                src: `func f(p *int) bool {
+        var q *int = nil
+        return p == nil && q == nil
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdbool.h>
+#include <stddef.h>
+bool f(int* p)
+{
+    int* q = NULL;
+    return ((p==NULL)&&(q==NULL));
+}
+`,
+        },
+        {
+                name: "labeled block break",
+                src: `func f(x int) int {
+done:
+        {
+                if x > 0 {
+                        break done
+                }
+                x = -x
+        }
+        return x
+}
+`,
+                want: `int f(int x)
+{
+    {
+        if ((x>0)) {
+            goto done_end;
+        }
+        x = -x;
+    }
+    done_end:;
+    return x;
+}
+`,
+        },
+        {
+                name: "multiple results",
+                src: `func divmod(a, b int) (int, int) {
+        return a / b, a % b
+}
+
+func f() int {
+        q, r := divmod(7, 2)
+        return q + r
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+void divmod(int a, int b, int* ret0, int* ret1)
+{
+    *ret0 = (a/b);
+    *ret1 = (a%b);
+    return;
+}
+int f()
+{
+    int q = 0;
+    int r = 0;
+    divmod(7, 2, &q, &r);
+    return (q+r);
+}
+`,
+        },
+        {
+                name: "multiple results passed on or discarded",
+                src: `func divmod(a, b int) (int, int) {
+        return a / b, a % b
+}
+
+func g(a, b int) (int, int) {
+        return divmod(a, b)
+}
+
+func f() {
+        divmod(1, 2)
+}
+`,
+                want: `void divmod(int a, int b, int* ret0, int* ret1)
+{
+    *ret0 = (a/b);
+    *ret1 = (a%b);
+    return;
+}
+void g(int a, int b, int* ret0, int* ret1)
+{
+    divmod(a, b, ret0, ret1);
+    return;
+}
+void f()
+{
+    {
+        int _t0;
+        int _t1;
+        divmod(1, 2, &_t0, &_t1);
+    }
+}
+`,
+        },
+        {
+                name: "multiple results as the arguments of a call",
+                src: `func two() (int, int) {
+        return 3, 4
+}
+
+func add(a, b int) int {
+        return a + b
+}
+
+func f() int {
+        x := add(two())
+        x = add(two())
+        return x
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+void two(int* ret0, int* ret1)
+{
+    *ret0 = 3;
+    *ret1 = 4;
+    return;
+}
+int add(int a, int b)
+{
+    return (a+b);
+}
+int f()
+{
+    int _a1_0 = 0;
+    int _a1_1 = 0;
+    two(&_a1_0, &_a1_1);
+    int x = add(_a1_0, _a1_1);
+    int _a2_0 = 0;
+    int _a2_1 = 0;
+    two(&_a2_0, &_a2_1);
+    x = add(_a2_0, _a2_1);
+    return x;
+}
+`,
+        },
+        {
+                name:  "error results passed on or discarded",
+                flags: map[string]string{"error-out": "true"},
+                src: `func parse(s string) (int, error) {
+        return 0, nil
+}
+
+func g(s string) (int, error) {
+        return parse(s)
+}
+
+func f() {
+        parse("1")
+}
+`,
+                want: `#include <stddef.h>
+typedef const char* error;
+int parse(char* s, error* err)
+{
+    *err = NULL;
+    return 0;
+}
+int g(char* s, error* err)
+{
+    return parse(s, err);
+}
+void f()
+{
+    {
+        error _t1;
+        parse("1", &_t1);
+    }
+}
+`,
+        },
+        {
+                name: "multiple assignment",
+                src: `func f(a, b int) int {
+        a, b = b, a
+        return a - b
+}
+`,
+                want: `int f(int a, int b)
+{
+    {
+        int _t0 = b;
+        int _t1 = a;
+        a = _t0;
+        b = _t1;
+    }
+    return (a-b);
+}
+`,
+        },
+        {
+                name: "short variable declarations",
+                src: `func f() float64 {
+        n := 1
+        x := 2.5
+        s := "s"
+        c := 'c'
+        ok := n > 0
+        _, _, _ = s, c, ok
+        return x
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdbool.h>
+#include <stdint.h>
+double f()
+{
+    int n = 1;
+    double x = 2.5;
+    char* s = "s";
+    int32_t c = 'c';
+    bool ok = (n>0);
+    (void)s;
+    (void)c;
+    (void)ok;
+    return x;
+}
+`,
+        },
+        {
+                name: "short variable declarations reading earlier targets",
+                src: `var g int
+
+func f() int {
+        a := 1
+        a, b := 2, a
+        g, a := 3, g
+        return a + b + g
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int g;
+int f()
+{
+    int a = 1;
+    int b = 0;
+    {
+        int _t0 = 2;
+        int _t1 = a;
+        a = _t0;
+        b = _t1;
+    }
+    /* unsupported: *ast.AssignStmt */
+    return ((a+b)+g);
+}
+`,
+        },
+        {
+                name: "consts",
+                src: `const Pi = 3.14159
+
+const (
+        Name = "goc"
+        Big  = 1 << 40
+)
+`,
+                want: `#define Pi 3.14159
+#define Name "goc"
+#define Big 1099511627776LL
+`,
+        },
+        {
+                name: "typed integer consts",
+                src: `import "fmt"
+
+const MaxLen int = 16
+const Mask uint64 = 1 << 40
+
+func f(x int) int {
+        var a [MaxLen]int
+        fmt.Println(MaxLen, Mask)
+        switch x {
+        case MaxLen:
+                return a[0]
+        }
+        return 0
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <inttypes.h>
+#include <stdint.h>
+#include <stdio.h>
+enum { MaxLen = 16 };
+#define Mask ((uint64_t)1099511627776LL)
+int f(int x)
+{
+    int a[MaxLen] = {0};
+    printf("%d %" PRIu64 "\n", MaxLen, (uint64_t)Mask);
+    switch (x) {
+    case MaxLen:
+        return a[0];
+    }
+    return 0;
+}
+`,
+        },
+        {
+                name: "len of a string literal as an array size",
+                src: `const n = len("hello")
+
+var buf [n]byte
+
+func f() int {
+        return len(buf)
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+#define n 5
+uint8_t buf[n];
+int f()
+{
+    return (int)(sizeof(buf)/sizeof(buf[0]));
+}
+`,
+        },
+        {
+                name: "const implicit repetition",
+                src: `const (
+        A = iota * 10
+        B
+        C
+)
+`,
+                want: `enum { A, B = 10, C = 20 };
+`,
+        },
+        {
+                name: "iota enum",
+                src: `type Color int
+
+const (
+        Red Color = iota
+        Green
+        Blue
+)
+`,
+                want: `typedef enum { Red, Green, Blue } Color;
+`,
+        },
+        {
+                name: "enum typedef ahead of its constants",
+                src: `type Color int
+
+func next(c Color) Color {
+        return c + Green
+}
+
+const (
+        Red Color = iota
+        Green
+)
+`,
+                want: `typedef enum { Red, Green } Color;
+Color next(Color c)
+{
+    return (c+Green);
+}
+`,
+        },
+        {
+                name: "shifted flag enum",
+                src: `type Perm uint8
+
+const (
+        Read Perm = 1 << iota
+        Write
+        Exec
+        Admin
+)
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+typedef uint8_t Perm;
+enum { Read = 1, Write, Exec = 4, Admin = 8 };
+`,
+        },
+        {
+                name: "enum constants as case labels",
+                src: `type Color int
+
+const (
+        Red Color = iota
+        Green
+        Blue
+)
+
+func f(c Color) int {
+        switch c {
+        case Red:
+                return 0
+        case Color(1):
+                return 1
+        case Color(Blue):
+                return 2
+        }
+        return -1
+}
+`,
+                want: `typedef enum { Red, Green, Blue } Color;
+int f(Color c)
+{
+    switch (c) {
+    case Red:
+        return 0;
+    case (Color)1:
+        return 1;
+    case (Color)Blue:
+        return 2;
+    }
+    return -1;
+}
+`,
+        },
+        {
+                name:  "dense switch under -computed-goto",
+                flags: map[string]string{"computed-goto": "true"},
+                src: `func f(c int) int {
+        switch c {
+        case 0:
+                return 10
+        case 1:
+                return 20
+        case 2, 3:
+                return 30
+        }
+        return 0
+}
+
+func g(c int) int {
+        switch c {
+        case 1:
+                return 1
+        case 100:
+                return 2
+        case 1000:
+                return 3
+        }
+        return 0
+}
+`,
+                want: `int f(int c)
+{
+#if defined(__GNUC__)
+    {
+        static void* _sw1_table[] = {&&_sw1_0, &&_sw1_1, &&_sw1_2, &&_sw1_2};
+        if (c < 0 || c > 3) {
+            goto _sw1_end;
+        }
+        goto *_sw1_table[c];
+        _sw1_0:;
+            return 10;
+        _sw1_1:;
+            return 20;
+        _sw1_2:;
+            return 30;
+    }
+    _sw1_end:;
+#else
+    switch (c) {
+    case 0:
+        return 10;
+    case 1:
+        return 20;
+    case 2:
+    case 3:
+        return 30;
+    }
+#endif
+    return 0;
+}
+int g(int c)
+{
+    switch (c) {
+    case 1:
+        return 1;
+    case 100:
+        return 2;
+    case 1000:
+        return 3;
+    }
+    return 0;
+}
+`,
+        },
+        {
+                name:  "enum strings",
+                flags: map[string]string{"enum-strings": "true"},
+                src: `type Color int
+
+const (
+        Red Color = iota
+        Green
+        Blue
+)
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stddef.h>
+typedef enum { Red, Green, Blue } Color;
+static const char* Color_names[3] = {
+    [Red] = "Red",
+    [Green] = "Green",
+    [Blue] = "Blue",
+};
+static inline const char* Color_String(Color v)
+{
+    if ((size_t)v >= 3 || Color_names[v] == NULL) {
+        return "Color(?)";
+    }
+    return Color_names[v];
+}
+`,
+        },
+        {
+                name: "array parameters by value",
+                src: `func sum(a [4]int) int {
+        a[0] = 0
+        return a[1] + a[2]
+}
+
+func f() int {
+        var a [4]int
+        return sum(a)
+}
+`,
+                want: `typedef struct { int a[4]; } Arr4_int;
+int sum(Arr4_int a)
+{
+    a.a[0] = 0;
+    return (a.a[1]+a.a[2]);
+}
+int f()
+{
+    int a[4] = {0};
+    return sum(*(Arr4_int*)a);
+}
+`,
+        },
+        {
+                name: "array locals assigned from wrappers and plain arrays",
+                src: `func mk() [4]int {
+        return [4]int{1, 2, 3, 4}
+}
+
+func f() int {
+        var x [4]int
+        z := mk()
+        z = x
+        x = z
+        x = mk()
+        return x[0] + z[0]
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 201112L
+#error "this file requires C11 or later"
+#endif
+#include <string.h>
+typedef struct { int a[4]; } Arr4_int;
+Arr4_int mk()
+{
+    return (Arr4_int){{1, 2, 3, 4}};
+}
+int f()
+{
+    int x[4] = {0};
+    Arr4_int z = mk();
+    z = *(Arr4_int*)x;
+    memcpy(x, z.a, sizeof x);
+    memcpy(x, mk().a, sizeof x);
+    return (x[0]+z.a[0]);
+}
+`,
+        },
+        {
+                name: "local shadowing a function",
+                src: `func g() int {
+        return 1
+}
+
+func f() int {
+        g := 2
+        return g
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int g()
+{
+    return 1;
+}
+int f()
+{
+    int g = 2;
+    return g;
+}
+`,
+        },
+        {
+                name: "local holding a function",
+                src: `func triple(x int) int {
+        return 3 * x
+}
+
+func f() int {
+        dbl := triple
+        return dbl(2)
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int triple(int x)
+{
+    return (3*x);
+}
+int f()
+{
+    int (*dbl)(int) = triple;
+    return dbl(2);
+}
+`,
+        },
+        {
+                name: "switch",
+                src: `func f(x int) int {
+        switch x {
+        case 1, 2:
+                return 10
+        case 3:
+                fallthrough
+        case 4:
+                x += 1
+        default:
+                x = 0
+        }
+        return x
+}
+`,
+                want: `int f(int x)
+{
+    switch (x) {
+    case 1:
+    case 2:
+        return 10;
+    case 3:
+    case 4:
+        x += 1;
+        break;
+    default:
+        x = 0;
+        break;
+    }
+    return x;
+}
+`,
+        },
+        {
+                name: "string switch",
+                src: `func f(s string) int {
+        switch s {
+        case "a":
+                return 1
+        }
+        return 0
+}
+`,
+                want: `#include <string.h>
+int f(char* s)
+{
+    if (strcmp(s, "a") == 0) {
+        return 1;
+    }
+    return 0;
+}
+`,
+        },
+        {
+                name: "range over array",
+                src: `func f() int {
+        a := [3]int{1, 2, 3}
+        n := 0
+        for i, v := range a {
+                n += i * v
+        }
+        return n
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int f()
+{
+    int a[3] = {1, 2, 3};
+    int n = 0;
+    for (int i = 0; i < 3; i++) {
+        int v = a[i];
+        n += (i*v);
+    }
+    return n;
+}
+`,
+        },
+        {
+                name: "range over int",
+                src: `func f() int {
+        n := 0
+        for i := range 10 {
+                n += i
+        }
+        return n
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int f()
+{
+    int n = 0;
+    for (int i = 0; i < 10; i++) {
+        n += i;
+    }
+    return n;
+}
+`,
+        },
+        {
+                name: "range over a variable count",
+                src: `func f(n int) int {
+        c := 0
+        for range n {
+                n--
+                c++
+        }
+        return c
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int f(int n)
+{
+    int c = 0;
+    for (int _i0 = 0, _n0 = n; _i0 < _n0; _i0++) {
+        n--;
+        c++;
+    }
+    return c;
+}
+`,
+        },
+        {
+                name:  "error out-parameter",
+                flags: map[string]string{"error-out": "true"},
+                src: `func parse(s string) (int, error) {
+        return 0, nil
+}
+`,
+                want: `#include <stddef.h>
+typedef const char* error;
+int parse(char* s, error* err)
+{
+    *err = NULL;
+    return 0;
+}
+`,
+        },
+        {
+                name: "break continue goto",
+                src: `func f() int {
+        n := 0
+outer:
+        for i := 0; i < 10; i++ {
+                for j := 0; j < 10; j++ {
+                        if j == 2 {
+                                continue outer
+                        }
+                        if i == 5 {
+                                break outer
+                        }
+                        n += 1
+                }
+        }
+        return n
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int f()
+{
+    int n = 0;
+    for (int i = 0; (i<10); i++) {
+        for (int j = 0; (j<10); j++) {
+            if ((j==2)) {
+                goto outer_continue;
+            }
+            if ((i==5)) {
+                goto outer_end;
+            }
+            n += 1;
+        }
+        outer_continue:;
+    }
+    outer_end:;
+    return n;
+}
+`,
+        },
+        {
+                name: "labels",
+                src: `func f(n int) int {
+        if n < 0 {
+                goto end
+        }
+        n *= 2
+end:
+        return n
+}
+`,
+                want: `int f(int n)
+{
+    if ((n<0)) {
+        goto end;
+    }
+    n *= 2;
+    end:
+    return n;
+}
+`,
+        },
+        {
+                name: "nested and empty blocks",
+                src: `func f() int {
+        x := 1
+        {
+                x := 2
+                _ = x
+        }
+        {
+        }
+        return x
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int f()
+{
+    int x = 1;
+    {
+        int x = 2;
+        (void)x;
+    }
+    {}
+    return x;
+}
+`,
+        },
+        {
+                name: "defer",
+                src: `func closeIt(n int) {
+}
+
+func f(x int) int {
+        defer closeIt(1)
+        if x > 0 {
+                return 1
+        }
+        return 0
+}
+`,
+                want: `void closeIt(int n)
+{
+}
+int f(int x)
+{
+    int _ret;
+    if ((x>0)) {
+        _ret = 1;
+        goto cleanup;
+    }
+    _ret = 0;
+    goto cleanup;
+    cleanup:
+    closeIt(1);
+    return _ret;
+}
+`,
+        },
+        {
+                name: "stacked defers with early returns",
+                src: `func say(n int) {
+}
+
+func f(x int) int {
+        if x < 0 {
+                return -1
+        }
+        defer say(1)
+        if x == 0 {
+                return 0
+        }
+        defer say(2)
+        return x
+}
+`,
+                want: `void say(int n)
+{
+}
+int f(int x)
+{
+    int _ret;
+    if ((x<0)) {
+        return -1;
+    }
+    if ((x==0)) {
+        _ret = 0;
+        goto cleanup1;
+    }
+    _ret = x;
+    goto cleanup;
+    cleanup:
+    say(2);
+    cleanup1:
+    say(1);
+    return _ret;
+}
+`,
+        },
+        {
+                name: "deferred arguments evaluated at the defer",
+                src: `func show(p *int) {
+}
+
+func f(p *int, q *int) {
+        defer show(p)
+        p = q
+        *p = 1
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+void show(int* p)
+{
+}
+void f(int* p, int* q)
+{
+    int* _d0_0 = p;
+    p = q;
+    *p = 1;
+    show(_d0_0);
+    return;
+}
+`,
+        },
+        {
+                name: "promoted method",
+                src: `type B2 struct {
+        N int
+}
+
+func (b *B2) M() int {
+        return b.N
+}
+
+type D struct {
+        B2
+        X int
+}
+
+func f(d D) int {
+        return d.M() + d.X
+}
+`,
+                want: `typedef struct B2 B2;
+struct B2 {
+    int N;
+};
+int B2_M(B2* b)
+{
+    return b->N;
+}
+typedef struct D D;
+struct D {
+    B2 B2;
+    int X;
+};
+int f(D d)
+{
+    return (B2_M(&d.B2)+d.X);
+}
+`,
+        },
+        {
+                name: "conversion between struct types",
+                src: `type P1 struct {
+        X, Y int
+}
+
+type P2 struct {
+        X int
+        Y int
+}
+
+func f(a P1) P2 {
+        return P2(a)
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+typedef struct P1 P1;
+struct P1 {
+    int X;
+    int Y;
+};
+typedef struct P2 P2;
+struct P2 {
+    int X;
+    int Y;
+};
+P2 f(P1 a)
+{
+    return (struct P2){.X = a.X, .Y = a.Y};
+}
+`,
+        },
+        {
+                name: "pointer field access",
+                src: `type P struct {
+        X int
+}
+
+func f(p *P) int {
+        return p.X
+}
+`,
+                want: `typedef struct P P;
+struct P {
+    int X;
+};
+int f(P* p)
+{
+    return p->X;
+}
+`,
+        },
+        {
+                name:  "pointer field access under -assert",
+                flags: map[string]string{"assert": "true"},
+                src: `type P struct {
+        X int
+}
+
+func f(p *P) int {
+        return p.X + (*p).X
+}
+`,
+                want: `#include <assert.h>
+#include <stddef.h>
+typedef struct P P;
+struct P {
+    int X;
+};
+int f(P* p)
+{
+    return ((assert(p != NULL), p)->X+(*(assert(p != NULL), p)).X);
+}
+`,
+        },
+        {
+                name:  "array index under -assert",
+                flags: map[string]string{"assert": "true"},
+                src: `func f(a [4]int, i int) int {
+        return a[i] + a[2]
+}
+`,
+                want: `#include <assert.h>
+typedef struct { int a[4]; } Arr4_int;
+int f(Arr4_int a, int i)
+{
+    return (a.a[(assert(i >= 0 && i < 4), i)]+a.a[2]);
+}
+`,
+        },
+        {
+                name: "printing signed to unsigned conversions",
+                src: `import "fmt"
+
+func f(x int32) {
+        fmt.Println(uint32(x), uint64(x))
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <inttypes.h>
+#include <stdint.h>
+#include <stdio.h>
+void f(int32_t x)
+{
+    printf("%" PRIu32 " %" PRIu64 "\n", (uint32_t)x, (uint64_t)x);
+}
+`,
+        },
+        {
+                name: "bare fmt.Println",
+                src: `import "fmt"
+
+func f() {
+        fmt.Println()
+}
+`,
+                want: `#include <stdio.h>
+void f()
+{
+    printf("\n");
+}
+`,
+        },
+        {
+                name: "fmt.Scan",
+                src: `import "fmt"
+
+func f() int {
+        var n int
+        fmt.Scan(&n)
+        return n
+}
+`,
+                want: `#include <stdio.h>
+int f()
+{
+    int n = 0;
+    scanf("%d", &n);
+    return n;
+}
+`,
+        },
+        {
+                name: "narrowing conversions",
+                src: `func f(x int) (int8, uint16) {
+        return int8(x), uint16(x)
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+void f(int x, int8_t* ret0, uint16_t* ret1)
+{
+    *ret0 = (int8_t)x;
+    *ret1 = (uint16_t)x;
+    return;
+}
+`,
+        },
+        {
+                name: "fmt.Println and Printf",
+                src: `import "fmt"
+
+func f(n int, s string) {
+        fmt.Println("n =", n, s)
+        fmt.Printf("%5d %s\n", n, s)
+}
+`,
+                want: `#include <stdio.h>
+void f(int n, char* s)
+{
+    printf("n = %d %s\n", n, s);
+    printf("%5d %s\n", n, s);
+}
+`,
+        },
+        {
+                name:  "fmt.Print under -int-size 64",
+                flags: map[string]string{"int-size": "64"},
+                src: `import "fmt"
+
+func f(n int, b int8) {
+        fmt.Print("a", 1, 2, n, b+1)
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <inttypes.h>
+#include <stdint.h>
+#include <stdio.h>
+void f(int64_t n, int8_t b)
+{
+    printf("a%" PRId64 " %" PRId64 " %" PRId64 " %" PRId8, (int64_t)1, (int64_t)2, n, (int8_t)(b+1));
+}
+`,
+        },
+        {
+                name: "len of arrays and strings",
+                src: `func f(s string) int {
+        var a [8]int
+        return len(a) + len(s) + len("abc")
+}
+`,
+                want: `#include <string.h>
+int f(char* s)
+{
+    int a[8] = {0};
+    return (((int)(sizeof(a)/sizeof(a[0]))+(int)strlen(s))+(int)strlen("abc"));
+}
+`,
+        },
+        {
+                name: "len of slices and maps",
+                src: `func f(s []int, m map[string]int) int {
+        return len(s) + len(m)
+}
+`,
+                want: `int f(/* unsupported: *ast.ArrayType */ s, /* unsupported: *ast.MapType */ m)
+{
+    return (/* unsupported: *ast.CallExpr */+/* unsupported: *ast.CallExpr */);
+}
+`,
+        },
+        {
+                name: "array pointers",
+                src: `func f(p *[4]int) int {
+        p[0] = len(p)
+        return p[1]
+}
+`,
+                want: `int f(int (*p)[4])
+{
+    (*p)[0] = (int)(4);
+    return (*p)[1];
+}
+`,
+        },
+        {
+                name: "grouped declarations",
+                src: `var (
+        a int
+        b float64
+)
+
+type (
+        X int
+        Y float64
+)
+`,
+                want: `int a;
+double b;
+typedef int X;
+typedef double Y;
+`,
+        },
+        {
+                name: "inline asm",
+                src: `func f() {
+        //goc:asm("nop")
+        return
+}
+`,
+                want: `void f()
+{
+    __asm__("nop");
+    return;
+}
+`,
+        },
+        {
+                name: "multiple names per var spec",
+                src: `var a, b int
+
+func f() int {
+        var x, y int = 1, 2
+        return x + y + a + b
+}
+`,
+                want: `int a;
+int b;
+int f()
+{
+    int x = 1;
+    int y = 2;
+    return (((x+y)+a)+b);
+}
+`,
+        },
+        {
+                name: "var specs without a type",
+                src: `import "fmt"
+
+var m = 3
+var s, r = "s", 'r'
+
+func f() {
+        var x, y = 1, 2.5
+        fmt.Println(m, s, x, y)
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+#include <stdint.h>
+#include <stdio.h>
+int m = 3;
+char* s = "s";
+int32_t r = 'r';
+void f()
+{
+    int x = 1;
+    double y = 2.5;
+    printf("%d %s %d %g\n", m, s, x, y);
+}
+`,
+        },
+        {
+                name: "embedded grouped and function-typed fields",
+                src: `type Base struct {
+        ID int
+}
+
+type T struct {
+        Base
+        X, Y int
+        Fn   func(int) int
+}
+`,
+                want: `typedef struct Base Base;
+struct Base {
+    int ID;
+};
+typedef struct T T;
+struct T {
+    Base Base;
+    int X;
+    int Y;
+    int (*Fn)(int);
+};
+`,
+        },
+        {
+                name: "for loop forms",
+                src: `func f() int {
+        n := 0
+        for {
+                n++
+                if n > 3 {
+                        break
+                }
+        }
+        for n < 10 {
+                n++
+        }
+        return n
+}
+`,
+                want: `#if !defined(__STDC_VERSION__) || __STDC_VERSION__ < 199901L
+#error "this file requires C99 or later"
+#endif
+int f()
+{
+    int n = 0;
+    for (;;) {
+        n++;
+        if ((n>3)) {
+            break;
+        }
+    }
+    while (n<10) {
+        n++;
+    }
+    return n;
+}
+`,
+        },
+        {
+                name: "blank and unnamed parameters",
+                src: `func f(_ int, y int) int {
+        return y
+}
+`,
+                want: `int f(int _unused0, int y)
+{
+    return y;
+}
+`,
+        },
+        {
+                name: "methods",
+                src: `type Celsius float64
+
+func (c Celsius) F() float64 {
+        return float64(c)*9/5 + 32
+}
+
+func f() float64 {
+        var c Celsius = 100
+        return c.F()
+}
+`,
+                want: `typedef double Celsius;
+double Celsius_F(Celsius c)
+{
+    return ((((double)c*9)/5)+32);
+}
+double f()
+{
+    Celsius c = 100;
+    return Celsius_F(c);
+}
+`,
+        },
+        {
+                name:  "features",
+                flags: map[string]string{"features": "true"},
+                src: `func f(c chan int) {
+        go f(c)
+        defer f(c)
+        go f(c)
+}
+`,
+                want: `defers                  1  supported
+goroutines              2  unsupported
+channels                1  unsupported
+`,
+        },
+}
+
+func TestTranslate(t *testing.T) {
+        for _, tt := range translateTests {
+                t.Run(tt.name, func(t *testing.T) {
+                        got, stderr, err := goc(t, t.TempDir(), tt.flags, tt.src)
+                        if err != nil {
+                                t.Fatalf("%v\n%s", err, stderr)
+                        }
+                        if got != tt.want {
+                                t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+                        }
+                })
+        }
+}
+
+var runTests = []struct {
+        name   string
+        flags  map[string]string
+        src    string
+        driver string
+        want   string
+}{
+        {
+                name: "hello world",
+                src: `import "fmt"
+
+func main() {
+        fmt.Println("hello, world")
+}
+`,
+                want: "hello, world\n",
+        },
+        {
+                name: "init functions",
+                src: `import "fmt"
+
+var n int
+
+func init() {
+        n = 2
+}
+
+func main() {
+        fmt.Println(n)
+}
+
+func init() {
+        n *= 10
+}
+`,
+                want: "20\n",
+        },
+        {
+                name: "logical operator precedence",
+                src: `func p(a int, b int, c int, d int) int {
+        if a < b && b < c || d == 1 {
+                return 1
+        }
+        return 0
+}
+
+func q(a int, b int, c int, d int) int {
+        if a > b || d == 0 && c == 3 {
+                return 1
+        }
+        return 0
+}
+`,
+                driver: `#include <stdio.h>
+
+int main(void)
+{
+        printf("%d %d %d ", p(1, 2, 3, 0), p(3, 2, 1, 1), p(3, 2, 1, 0));
+        printf("%d %d %d\n", q(2, 1, 0, 1), q(1, 2, 3, 0), q(1, 2, 3, 1));
+        return 0;
+}
+`,
+                want: "1 1 0 1 1 0\n",
+        },
+        {
+                name: "short-circuit evaluates operands once",
+                src: `var calls int
+
+func hit(v int) int {
+        calls += 1
+        return v
+}
+
+func f() int {
+        if hit(0) == 1 && hit(1) == 1 {
+                return 0
+        }
+        if hit(1) == 1 || hit(0) == 1 {
+                return calls
+        }
+        return 0
+}
+`,
+                driver: `#include <stdio.h>
+
+int main(void)
+{
+        printf("%d\n", f());
+        return 0;
+}
+`,
+                want: "2\n",
+        },
+        {
+                name:  "error out-parameter",
+                flags: map[string]string{"error-out": "true"},
+                src: `import "errors"
+
+func half(n int) (int, error) {
+        if n%2 != 0 {
+                return 0, errors.New("odd")
+        }
+        return n / 2, nil
+}
+`,
+                driver: `#include <stdio.h>
+
+int main(void)
+{
+        error err;
+        int n = half(3, &err);
+        printf("%d %s\n", n, err);
+        n = half(4, &err);
+        printf("%d %d\n", n, err == NULL);
+        return 0;
+}
+`,
+                want: "0 odd\n2 1\n",
+        },
+        {
+                name: "control flow",
+                src: `import "fmt"
+
+func divmod(a int, b int) (int, int) {
+        return a / b, a % b
+}
+
+func kind(x int) string {
+        switch x {
+        case 0:
+                return "zero"
+        case 1, 2:
+                return "small"
+        }
+        return "big"
+}
+
+func main() {
+        q, r := divmod(17, 5)
+        fmt.Println(q, r)
+        a := [4]int{1, 2, 3, 4}
+        n := 0
+        for i, v := range a {
+                n += i * v
+        }
+        fmt.Println(n, kind(0), kind(2), kind(9))
+        x, y := 1, 2
+        x, y = y, x
+        fmt.Printf("%d %d\n", x, y)
+}
+`,
+                want: "3 2\n20 zero small big\n2 1\n",
+        },
+        {
+                name: "multiple results passed on or discarded",
+                src: `import "fmt"
+
+func divmod(a, b int) (int, int) {
+        return a / b, a % b
+}
+
+func pass(a, b int) (int, int) {
+        return divmod(a, b)
+}
+
+func main() {
+        divmod(1, 0+1)
+        q, r := pass(17, 5)
+        fmt.Println(q, r)
+}
+`,
+                want: "3 2\n",
+        },
+        {
+                name: "multiple results as the arguments of a call",
+                src: `import "fmt"
+
+func divmod(a, b int) (int, int) {
+        return a / b, a % b
+}
+
+func add(a, b int) int {
+        return a + b
+}
+
+func show(q, r int) {
+        fmt.Println(q, r)
+}
+
+func main() {
+        show(divmod(17, 5))
+        x := add(divmod(17, 5))
+        fmt.Println(x)
+        x = add(divmod(20, 3))
+        fmt.Println(x)
+}
+`,
+                want: "3 2\n5\n8\n",
+        },
+        {
+                name: "range over a count changed by the body",
+                src: `import "fmt"
+
+func main() {
+        n := 3
+        c := 0
+        for range n {
+                n--
+                c++
+        }
+        fmt.Println(c, n)
+}
+`,
+                want: "3 0\n",
+        },
+        {
+                name: "stacked defers with early returns",
+                src: `import "fmt"
+
+func say(n int) {
+        fmt.Println(n)
+}
+
+func f(x int) int {
+        if x < 0 {
+                return -1
+        }
+        defer say(1)
+        if x == 0 {
+                return 0
+        }
+        defer say(2)
+        return x
+}
+
+func main() {
+        fmt.Println(f(-1))
+        fmt.Println(f(0))
+        fmt.Println(f(5))
+}
+`,
+                want: "-1\n1\n0\n2\n1\n5\n",
+        },
+        {
+                name: "deferred arguments evaluated at the defer",
+                src: `import "fmt"
+
+type Box struct {
+        V int
+}
+
+func show(b *Box, n int) {
+        fmt.Println(b.V, n)
+}
+
+func main() {
+        a := &Box{V: 1}
+        b := &Box{V: 2}
+        n := 10
+        p := a
+        defer show(p, n)
+        p = b
+        n = 20
+        a.V = 3
+}
+`,
+                want: "3 10\n",
+        },
+        {
+                name: "short variable declaration reading a redeclared name",
+                src: `import "fmt"
+
+func main() {
+        x := 1
+        x, z := 3, x
+        fmt.Println(x, z)
+}
+`,
+                want: "3 1\n",
+        },
+        {
+                name: "array copies",
+                src: `import "fmt"
+
+func mk() [3]int {
+        return [3]int{1, 2, 3}
+}
+
+func main() {
+        var x [3]int
+        z := mk()
+        y := z
+        z[0] = 9
+        x = z
+        z = [3]int{4, 5, 6}
+        fmt.Println(x[0], y[0], z[0])
+}
+`,
+                want: "9 1 4\n",
+        },
+        {
+                name: "methods on structs",
+                src: `import "fmt"
+
+type Point struct {
+        X, Y int
+        Next *Point
+}
+
+func (p *Point) Move(dx int) {
+        p.X += dx
+}
+
+func (p Point) Sum() int {
+        return p.X + p.Y
+}
+
+func main() {
+        p := Point{X: 1, Y: 2}
+        p.Move(3)
+        fmt.Println(p.Sum())
+}
+`,
+                want: "6\n",
+        },
+        {
+                name: "promoted methods",
+                src: `import "fmt"
+
+type B2 struct {
+        N int
+}
+
+func (b *B2) M() int {
+        return b.N
+}
+
+func (b B2) V() int {
+        return b.N * 10
+}
+
+type Mid struct {
+        B2
+}
+
+type D struct {
+        *Mid
+        X int
+}
+
+func main() {
+        d := D{Mid: &Mid{B2: B2{N: 4}}, X: 1}
+        fmt.Println(d.M(), d.V())
+        p := &d
+        fmt.Println(p.M())
+}
+`,
+                want: "4 40\n4\n",
+        },
+        {
+                name: "conversion between struct types",
+                src: `import "fmt"
+
+type P1 struct {
+        X, Y int
+}
+
+type P2 struct {
+        X int
+        Y int
+}
+
+func main() {
+        a := P1{X: 1, Y: 2}
+        b := P2(a)
+        a.X = 5
+        fmt.Println(a.X, b.X, b.Y)
+}
+`,
+                want: "5 1 2\n",
+        },
+        {
+                name:  "dense switch under -computed-goto",
+                flags: map[string]string{"computed-goto": "true"},
+                src: `import "fmt"
+
+func op(code int, x int) int {
+        for i := 0; i < 1; i++ {
+                switch code {
+                case 1:
+                        x += 10
+                case 2, 3:
+                        x *= 2
+                        fallthrough
+                case 5:
+                        x++
+                default:
+                        x = -x
+                case 4:
+                        y := x * 3
+                        x = y
+                        break
+                }
+        }
+        return x
+}
+
+func main() {
+        for c := 0; c < 7; c++ {
+                fmt.Println(op(c, 5))
+        }
+}
+`,
+                want: "-5\n15\n11\n11\n15\n6\n-5\n",
+        },
+        {
+                name: "negative int32 to unsigned",
+                src: `import "fmt"
+
+func main() {
+        var x int32 = -5
+        a := uint32(x)
+        b := uint64(x)
+        fmt.Println(a, b, uint32(x), uint64(x))
+}
+`,
+                want: "4294967291 18446744073709551611 4294967291 18446744073709551611\n",
+        },
+        {
+                name: "array pointers",
+                src: `import "fmt"
+
+func sum(p *[4]int) int {
+        n := 0
+        for i := 0; i < len(p); i++ {
+                n += p[i]
+        }
+        p[0] = 100
+        return n
+}
+
+func main() {
+        a := [4]int{1, 2, 3, 4}
+        var q *[4]int = &a
+        n := sum(q)
+        fmt.Println(n, a[0], q[1])
+}
+`,
+                want: "10 100 2\n",
+        },
+        {
+                name: "zero-length arrays",
+                src: `import "fmt"
+
+func main() {
+        z := [0]int{}
+        var w [2][0]int
+        fmt.Println(len(z), len(w), len([0]int{}))
+}
+`,
+                want: "0 2 0\n",
+        },
+        {
+                name: "enum switch",
+                src: `import "fmt"
+
+type Color int
+
+const (
+        Red Color = iota
+        Green
+        Blue
+)
+
+func code(c Color) int {
+        switch c {
+        case Red:
+                return 10
+        case Color(1):
+                return 11
+        case Color(Blue):
+                return 12
+        }
+        return -1
+}
+
+func main() {
+        fmt.Println(code(Red), code(Green), code(Blue), code(Color(7)))
+}
+`,
+                want: "10 11 12 -1\n",
+        },
+}
+
+// TestRun compiles the translated programs and checks what they print.
+// A program with a driver is translated as a library and linked with
+// the driver's C main; the others are translated with -standalone.
+// Either way the flags of the test are added.
+func TestRun(t *testing.T) {
+        cc, err := exec.LookPath("cc")
+        if err != nil {
+                t.Skip("no C compiler")
+        }
+        for _, tt := range runTests {
+                t.Run(tt.name, func(t *testing.T) {
+                        dir := t.TempDir()
+                        flags := map[string]string{"standalone": "true"}
+                        if tt.driver != "" {
+                                flags = map[string]string{}
+                        }
+                        for name, v := range tt.flags {
+                                flags[name] = v
+                        }
+                        out, stderr, err := goc(t, dir, flags, tt.src)
+                        if err != nil {
+                                t.Fatalf("%v\n%s", err, stderr)
+                        }
+                        src, bin := filepath.Join(dir, "main.c"), filepath.Join(dir, "main")
+                        if err := os.WriteFile(src, []byte(out+tt.driver), 0644); err != nil {
+                                t.Fatal(err)
+                        }
+                        if msg, err := exec.Command(cc, "-std=c11", "-o", bin, src).CombinedOutput(); err != nil {
+                                t.Fatalf("%v\n%s\n%s", err, msg, out)
+                        }
+                        got, err := exec.Command(bin).Output()
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if string(got) != tt.want {
+                                t.Errorf("got %q, want %q", got, tt.want)
+                        }
+                })
+        }
+}
+
+var errorTests = []struct {
+        name  string
+        flags map[string]string
+        src   string
+        err   string
+}{
+        {
+                name: "bool of a numeric constant",
+                src: `func f() bool {
+        return bool(1)
+}
+`,
+                err: "test.go:4:16: cannot convert 1 to bool",
+        },
+        {
+                name: "bool of a numeric variable",
+                src: `type Flag bool
+
+func f(x int, y float64) bool {
+        return bool(Flag(x)) || bool(y)
+}
+`,
+                err: "test.go:6:21: cannot convert x to bool",
+        },
+        {
+                name: "invalid asm directive",
+                src: `func f() {
+        //goc:asm(nop)
+}
+`,
+                err: "test.go:4:9: invalid goc:asm directive: //goc:asm(nop)",
+        },
+        {
+                name: "overflowing typed constant",
+                src: `const Small int8 = 200
+`,
+                err: "test.go:3:20: constant 200 overflows int8",
+        },
+        {
+                name: "bitfield wider than its type",
+                src: `type T struct {
+        A uint8 ` + "`" + `goc:"bits:9"` + "`" + `
+}
+`,
+                err: "test.go:4:17: 9-bit field is wider than its 8-bit type",
+        },
+        {
+                name: "flexible array member not last",
+                src: `type T struct {
+        //goc:flex
+        Data [0]byte
+        N    int
+}
+`,
+                err: "test.go:5:9: goc:flex field must be the last field of its struct",
+        },
+}
+
+func TestErrors(t *testing.T) {
+        for _, tt := range errorTests {
+                t.Run(tt.name, func(t *testing.T) {
+                        _, stderr, err := goc(t, t.TempDir(), tt.flags, tt.src)
+                        lines := strings.Split(strings.TrimSpace(stderr), "\n")
+                        if got := lines[len(lines)-1]; err == nil || got != tt.err {
+                                t.Errorf("got error %q, want %q", got, tt.err)
+                        }
+                })
+        }
+}
+
+var warningTests = []struct {
+        name  string
+        flags map[string]string
+        src   string
+        warn  string
+}{
+        {
+                name:  "switch missing an enum constant",
+                flags: map[string]string{"exhaustive": "true"},
+                src: `type Color int
+
+const (
+        Red Color = iota
+        Green
+        Blue
+)
+
+func f(c Color) int {
+        switch c {
+        case Red:
+                return 0
+        case Color(2):
+                return 2
+        }
+        return -1
+}
+`,
+                warn: "test.go:12:9: warning: switch on Color misses Green",
+        },
+        {
+                name: "switch missing an enum constant without -exhaustive",
+                src: `type Color int
+
+const (
+        Red Color = iota
+        Green
+        Blue
+)
+
+func f(c Color) int {
+        switch c {
+        case Red:
+                return 0
+        case Color(2):
+                return 2
+        }
+        return -1
+}
+`,
+        },
+        {
+                name:  "switch with a default",
+                flags: map[string]string{"exhaustive": "true"},
+                src: `type Color int
+
+const (
+        Red Color = iota
+        Green
+)
+
+func f(c Color) int {
+        switch c {
+        case Red:
+                return 0
+        default:
+                return 1
+        }
+}
+`,
+        },
+}
+
+// TestWarnings checks the warnings printed by translations that succeed.
+func TestWarnings(t *testing.T) {
+        for _, tt := range warningTests {
+                t.Run(tt.name, func(t *testing.T) {
+                        _, stderr, err := goc(t, t.TempDir(), tt.flags, tt.src)
+                        if err != nil {
+                                t.Fatalf("%v\n%s", err, stderr)
+                        }
+                        if got := strings.TrimSpace(stderr); got != tt.warn {
+                                t.Errorf("got warnings %q, want %q", got, tt.warn)
+                        }
+                })
+        }
+}
+
+var reportTests = []struct {
+        name string
+        src  string
+        want []Unsupported
+}{
+        {
+                name: "function literal",
+                src:  "func f() {\n        g := func() {}\n        g()\n}\n",
+                want: []Unsupported{{Type: "*ast.FuncLit", File: "test.go", Line: 4, Col: 14, Snippet: "func() {}"}},
+        },
+        {
+                name: "multiple results in a single-value context",
+                src:  "func two() (int, int) {\n        return 1, 2\n}\n\nfunc f() int {\n        return 2 * add(two())\n}\n",
+                want: []Unsupported{{Type: "*ast.CallExpr", File: "test.go", Line: 8, Col: 24, Snippet: "two()"}},
+        },
+}
+
+// TestReport checks the unsupported constructs that -report writes.
+func TestReport(t *testing.T) {
+        for _, tt := range reportTests {
+                t.Run(tt.name, func(t *testing.T) {
+                        dir := t.TempDir()
+                        if _, stderr, err := goc(t, dir, map[string]string{"report": "report.json"}, tt.src); err != nil {
+                                t.Fatalf("%v\n%s", err, stderr)
+                        }
+                        data, err := os.ReadFile(filepath.Join(dir, "report.json"))
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        var got []Unsupported
+                        if err := json.Unmarshal(data, &got); err != nil {
+                                t.Fatal(err)
+                        }
+                        if !reflect.DeepEqual(got, tt.want) {
+                                t.Errorf("got %+v, want %+v", got, tt.want)
+                        }
+                })
+        }
+}
+
+// TestStable checks that map iteration never leaks into the output.
+func TestStable(t *testing.T) {
+        src := "import \"fmt\"\n\ntype A struct {\n        X uint8\n        Y int64\n}\n\nfunc f(a [2]int, b [3]int, c [4]int) bool {\n        fmt.Println(a[0], b[0], c[0], 1i)\n        return a[0] != 0\n}\n"
+        dir := t.TempDir()
+        want, stderr, err := goc(t, dir, nil, src)
+        if err != nil {
+                t.Fatalf("%v\n%s", err, stderr)
+        }
+        for i := 0; i < 10; i++ {
+                if got, _, _ := goc(t, dir, nil, src); got != want {
+                        t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+                }
+        }
+}